@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/serlenario/referral-system/internal/captcha"
+	"github.com/serlenario/referral-system/internal/config"
+	"github.com/serlenario/referral-system/internal/ratelimit"
+)
+
+func newCaptchaPolicy(cfg config.Captcha, store ratelimit.Store) (*captcha.Policy, error) {
+	var verifier captcha.Verifier
+	if cfg.Mode != captcha.ModeOff {
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("CAPTCHA_SECRET is required when CAPTCHA_MODE is %q", cfg.Mode)
+		}
+		verifier = captcha.NewHTTPVerifier(cfg.VerifyURL, cfg.Secret)
+	}
+	return captcha.NewPolicy(verifier, cfg.Mode, store, cfg.RiskSignupsPerIP)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/serlenario/referral-system/internal/config"
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+)
+
+func newEmailPolicy(cfg config.EmailPolicy) (*emailpolicy.Policy, error) {
+	disposable, err := emailpolicy.NewDomainList(cfg.DisposableFile, cfg.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	return emailpolicy.NewPolicy(disposable, cfg.DisposableAction)
+}
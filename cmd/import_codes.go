@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+// importCodes implements the import-codes command:
+//
+//	go run ./cmd import-codes -file codes.csv [-campaign 3] [-dry-run]
+//
+// It prints the import report as JSON and exits with status 1 if any row was
+// rejected.
+func importCodes(campaignService services.CampaignService, args []string) int {
+	flags := flag.NewFlagSet("import-codes", flag.ExitOnError)
+	path := flags.String("file", "", "CSV file with code, owner_email, expires_at and max_uses columns")
+	campaign := flags.Uint("campaign", 0, "campaign the codes belong to")
+	dryRun := flags.Bool("dry-run", false, "only validate the file")
+	_ = flags.Parse(args)
+
+	if *path == "" {
+		flags.Usage()
+		return 2
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		log.Printf("failed to open %s: %v", *path, err)
+		return 1
+	}
+	defer file.Close()
+
+	var campaignID *uint
+	if *campaign != 0 {
+		id := *campaign
+		campaignID = &id
+	}
+
+	report, err := campaignService.ImportCodes(file, campaignID, *dryRun)
+	if err != nil {
+		log.Printf("import failed: %v", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Printf("failed to write report: %v", err)
+		return 1
+	}
+
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
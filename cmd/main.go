@@ -2,14 +2,19 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/serlenario/referral-system/internal/config"
 	"github.com/serlenario/referral-system/internal/controllers"
 	"github.com/serlenario/referral-system/internal/middleware"
 	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/notify"
+	"github.com/serlenario/referral-system/internal/pii"
+	"github.com/serlenario/referral-system/internal/ratelimit"
 	"github.com/serlenario/referral-system/internal/repositories"
 	"github.com/serlenario/referral-system/internal/services"
+	"github.com/serlenario/referral-system/internal/utils"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
@@ -21,36 +26,224 @@ import (
 func main() {
 	cfg := config.LoadConfig()
 
+	keyring, err := newKeyring(cfg.PII)
+	if err != nil {
+		log.Fatalf("invalid PII encryption settings: %v", err)
+	}
+	if !keyring.Enabled() {
+		log.Println("PII_KEYS is not set, personal data is stored unencrypted")
+	}
+	pii.Use(keyring)
+
 	dsn := "host=" + cfg.DBHost + " user=" + cfg.DBUser + " password=" + cfg.DBPassword + " dbname=" + cfg.DBName + " port=" + cfg.DBPort + " sslmode=disable"
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		log.Fatalf("failed to connect to database: %v", err)
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.Referral{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.UserStatusChange{}, &models.Referral{}, &models.Campaign{}, &models.PromoCode{}, &models.CodeBatch{}, &models.Reward{}, &models.AuditEntry{}, &models.LoginThrottle{}, &models.RateLimitBucket{}, &models.UserIdentity{}, &models.OAuthState{}, &models.MagicLink{}, &models.APIKey{}, &models.Session{}, &models.EmailChange{}, &models.TermsVersion{}, &models.TermsAcceptance{}); err != nil {
 		log.Fatalf("failed to run migrations: %v", err)
 	}
 
 	userRepo := repositories.NewUserRepository(db)
 	referralRepo := repositories.NewReferralRepository(db)
-	userService := services.NewUserService(userRepo, referralRepo, cfg.JWTSecret)
-	userController := controllers.NewUserController(userService)
+	campaignRepo := repositories.NewCampaignRepository(db)
+	promoCodeRepo := repositories.NewPromoCodeRepository(db)
+	rewardRepo := repositories.NewRewardRepository(db)
+	auditRepo := repositories.NewAuditRepository(db)
+	loginThrottleRepo := repositories.NewLoginThrottleRepository(db)
+	identityRepo := repositories.NewIdentityRepository(db)
+	magicLinkRepo := repositories.NewMagicLinkRepository(db)
+	apiKeyRepo := repositories.NewAPIKeyRepository(db)
+	sessionRepo := repositories.NewSessionRepository(db)
+	emailChangeRepo := repositories.NewEmailChangeRepository(db)
+	termsRepo := repositories.NewTermsRepository(db)
+	passwordValidator, err := newPasswordValidator(cfg.PasswordPolicy, cfg.PasswordHashing)
+	if err != nil {
+		log.Fatalf("invalid password policy: %v", err)
+	}
+	passwordHasher, err := newPasswordHasher(cfg.PasswordHashing)
+	if err != nil {
+		log.Fatalf("invalid password hashing settings: %v", err)
+	}
+	emailPolicy, err := newEmailPolicy(cfg.EmailPolicy)
+	if err != nil {
+		log.Fatalf("invalid email policy: %v", err)
+	}
+	emailMasker := utils.NewEmailMasker(cfg.EmailMaskVisibleChars, cfg.EmailMaskDomain)
+	notifier := notify.New(cfg.Notify)
+	auditService := services.NewAuditService(auditRepo)
+	loginThrottleService := services.NewLoginThrottleService(loginThrottleRepo, auditService, notifier, cfg.LoginPolicy)
+	sessionService := services.NewSessionService(sessionRepo, auditService)
+	termsService := services.NewTermsService(termsRepo, rewardRepo, auditService)
+	userService := services.NewUserService(userRepo, referralRepo, promoCodeRepo, campaignRepo, auditService, loginThrottleService, sessionService, termsService, passwordValidator, passwordHasher, emailPolicy, emailMasker, cfg.JWTSecret)
+	leaderboardService := services.NewLeaderboardService(userRepo, referralRepo, emailMasker, cfg.LeaderboardCacheTTL)
+	exportService := services.NewExportService(userRepo, referralRepo, rewardRepo, emailMasker)
+	campaignService := services.NewCampaignService(campaignRepo, promoCodeRepo, userRepo)
+	oidcService := services.NewOIDCService(oidcProviders(cfg.OIDCProviders), identityRepo, userRepo, apiKeyRepo, emailChangeRepo, userService, sessionService, auditService)
+	magicLinkService := services.NewMagicLinkService(magicLinkRepo, userRepo, userService, auditService, notifier, cfg.MagicLinkURL, cfg.MagicLinkTTL, cfg.JWTSecret)
+	accountService := services.NewAccountService(userRepo, emailChangeRepo, sessionService, loginThrottleService, auditService, notifier, passwordValidator, passwordHasher, emailPolicy, cfg.EmailChangeURL, cfg.EmailChangeTTL)
+	privacyService := services.NewPrivacyService(userRepo, referralRepo, rewardRepo, promoCodeRepo, auditRepo, identityRepo, sessionRepo, apiKeyRepo, magicLinkRepo, emailChangeRepo, loginThrottleRepo, termsRepo, accountService, auditService, notifier, cfg.AccountDeletionGrace)
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo, userRepo, auditService)
+	adminService := services.NewAdminService(userRepo, promoCodeRepo, loginThrottleService, auditService)
+	var rateLimitStore ratelimit.Store
+	switch cfg.RateLimits.Store {
+	case "memory":
+		rateLimitStore = ratelimit.NewMemoryStore()
+	case "postgres":
+		rateLimitStore = ratelimit.NewPostgresStore(db, max(cfg.RateLimits.LongestPeriod(), cfg.Captcha.RiskSignupsPerIP.Period))
+	default:
+		log.Fatalf("unknown rate limit store %q", cfg.RateLimits.Store)
+	}
+
+	captchaPolicy, err := newCaptchaPolicy(cfg.Captcha, rateLimitStore)
+	if err != nil {
+		log.Fatalf("invalid captcha settings: %v", err)
+	}
+	userController := controllers.NewUserController(userService, magicLinkService, captchaPolicy)
+	leaderboardController := controllers.NewLeaderboardController(leaderboardService)
+	exportController := controllers.NewExportController(exportService)
+	campaignController := controllers.NewCampaignController(campaignService)
+	adminController := controllers.NewAdminController(adminService, userService)
+	auditController := controllers.NewAuditController(auditService)
+	oidcController := controllers.NewOIDCController(oidcService, captchaPolicy)
+	magicLinkController := controllers.NewMagicLinkController(magicLinkService)
+	apiKeyController := controllers.NewAPIKeyController(apiKeyService)
+	sessionController := controllers.NewSessionController(sessionService)
+	accountController := controllers.NewAccountController(accountService, privacyService)
+	termsController := controllers.NewTermsController(termsService)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import-codes":
+			os.Exit(importCodes(campaignService, os.Args[2:]))
+		case "rotate-pii-keys":
+			os.Exit(rotatePIIKeys(db, keyring, os.Args[2:]))
+		case "purge-accounts":
+			os.Exit(purgeAccounts(privacyService))
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+	}
+
+	if err := campaignService.ResumeCodeBatches(); err != nil {
+		log.Printf("failed to resume code batches: %v", err)
+	}
+	go runAccountPurger(privacyService)
 
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	router.POST("/register", userController.Register)
-	router.POST("/login", userController.Login)
-	router.POST("/register_with_referral", userController.RegisterWithReferral)
-	router.GET("/referral_code", userController.GetReferralCodeByEmail)
+	router.POST("/register", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "register", Limit: cfg.RateLimits.Register, Key: ratelimit.ByIP},
+	), userController.Register)
+	router.POST("/login", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "login", Limit: cfg.RateLimits.Login, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "login", Limit: cfg.RateLimits.LoginPerEmail, Key: ratelimit.ByEmail},
+	), userController.Login)
+	router.POST("/login/magic_link", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "magic_link", Limit: cfg.RateLimits.MagicLink, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "magic_link", Limit: cfg.RateLimits.MagicLink, Key: ratelimit.ByEmail},
+	), magicLinkController.SendLoginLink)
+	router.GET("/login/magic_link/verify", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "login", Limit: cfg.RateLimits.Login, Key: ratelimit.ByIP},
+	), magicLinkController.Verify)
+	router.GET("/email/confirm", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "email_confirm", Limit: cfg.RateLimits.Login, Key: ratelimit.ByIP},
+	), accountController.ConfirmEmail)
+	router.POST("/register_with_referral", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "register_with_referral", Limit: cfg.RateLimits.RegisterWithReferral, Key: ratelimit.ByIP},
+	), userController.RegisterWithReferral)
+	router.GET("/referral_code", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "referral_code", Limit: cfg.RateLimits.ReferralCodeLookup, Key: ratelimit.ByIP},
+	), userController.GetReferralCodeByEmail)
+	router.GET("/leaderboard", leaderboardController.GetLeaderboard)
+	router.GET("/terms", termsController.GetCurrentTerms)
+
+	router.GET("/auth/oidc/providers", oidcController.ListProviders)
+	router.GET("/auth/oidc/:provider/login", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "login", Limit: cfg.RateLimits.Login, Key: ratelimit.ByIP},
+	), oidcController.StartLogin)
+	router.GET("/auth/oidc/:provider/callback", ratelimit.Middleware(rateLimitStore,
+		ratelimit.Rule{Name: "login", Limit: cfg.RateLimits.Login, Key: ratelimit.ByIP},
+	), oidcController.Callback)
 
 	authorized := router.Group("/")
-	authorized.Use(middleware.JWTMiddleware(cfg.JWTSecret))
+	authorized.Use(
+		middleware.AuthMiddleware(cfg.JWTSecret, userRepo, sessionService, apiKeyService),
+		ratelimit.Middleware(rateLimitStore, ratelimit.Rule{Name: "authenticated", Limit: cfg.RateLimits.Authenticated, Key: ratelimit.ByUserID}),
+	)
+	{
+		authorized.POST("/referral_code", middleware.RequireScope(models.ScopeReferralCodesWrite), userController.CreateReferralCode)
+		authorized.DELETE("/referral_code", middleware.RequireScope(models.ScopeReferralCodesWrite), userController.DeleteReferralCode)
+		authorized.GET("/referral_code/me", middleware.RequireScope(models.ScopeReferralCodesRead), userController.GetOwnReferralCode)
+		authorized.PUT("/referral_code/visibility", middleware.RequireScope(models.ScopeReferralCodesWrite), userController.SetReferralCodeVisibility)
+		authorized.GET("/referrals", middleware.RequireScope(models.ScopeReferralsList), userController.GetReferrals)
+		authorized.GET("/referrals/export", middleware.RequireScope(models.ScopeReferralsList), exportController.ExportReferrals)
+		authorized.PUT("/leaderboard/preferences", middleware.RequireScope(models.ScopeLeaderboardWrite), leaderboardController.UpdatePreferences)
+
+		authorized.POST("/api_keys", middleware.RejectAPIKeys(), apiKeyController.CreateAPIKey)
+		authorized.GET("/api_keys", middleware.RejectAPIKeys(), apiKeyController.ListAPIKeys)
+		authorized.DELETE("/api_keys/:id", middleware.RejectAPIKeys(), apiKeyController.RevokeAPIKey)
+
+		authorized.GET("/me", middleware.RejectAPIKeys(), accountController.GetProfile)
+		authorized.PATCH("/me", middleware.RejectAPIKeys(), accountController.UpdateProfile)
+		authorized.DELETE("/me", middleware.RejectAPIKeys(), accountController.DeleteAccount)
+		authorized.GET("/me/export", middleware.RejectAPIKeys(), ratelimit.Middleware(rateLimitStore,
+			ratelimit.Rule{Name: "data_export", Limit: cfg.RateLimits.DataExport, Key: ratelimit.ByUserID},
+		), accountController.ExportData)
+		authorized.PUT("/me/password", middleware.RejectAPIKeys(), accountController.ChangePassword)
+		authorized.POST("/me/email", middleware.RejectAPIKeys(), ratelimit.Middleware(rateLimitStore,
+			ratelimit.Rule{Name: "email_change", Limit: cfg.RateLimits.EmailChange, Key: ratelimit.ByUserID},
+		), accountController.ChangeEmail)
+
+		authorized.GET("/me/terms", middleware.RejectAPIKeys(), termsController.GetTermsStatus)
+		authorized.POST("/me/terms", middleware.RejectAPIKeys(), termsController.AcceptTerms)
+
+		authorized.GET("/sessions", middleware.RejectAPIKeys(), sessionController.ListSessions)
+		authorized.DELETE("/sessions/:id", middleware.RejectAPIKeys(), sessionController.RevokeSession)
+	}
+
+	admin := authorized.Group("/admin")
+	{
+		admin.GET("/users", middleware.RequirePermission(models.PermissionUsersRead), adminController.SearchUsers)
+		admin.GET("/users/:id", middleware.RequirePermission(models.PermissionUsersRead), adminController.GetUser)
+		admin.GET("/users/:id/referrals", middleware.RequirePermission(models.PermissionReferralsRead), adminController.GetUserReferrals)
+		admin.DELETE("/users/:id/referral_codes", middleware.RequirePermission(models.PermissionCodesRevoke), adminController.RevokeReferralCodes)
+		admin.POST("/referrals/:id/approve", middleware.RequirePermission(models.PermissionReferralsReview), adminController.ApproveReferral)
+		admin.POST("/users/:id/status", middleware.RequirePermission(models.PermissionUsersSuspend), adminController.SetUserStatus)
+		admin.GET("/users/:id/status_history", middleware.RequirePermission(models.PermissionUsersSuspend), adminController.GetStatusHistory)
+		admin.POST("/users/:id/unlock", middleware.RequirePermission(models.PermissionUsersSuspend), adminController.UnlockUser)
+		admin.PUT("/users/:id/role", middleware.RequirePermission(models.PermissionUsersRoles), adminController.SetUserRole)
+
+		admin.GET("/export/referrals", middleware.RequirePermission(models.PermissionExportsRead), exportController.ExportAllReferrals)
+		admin.GET("/export/users", middleware.RequirePermission(models.PermissionExportsRead), exportController.ExportUsers)
+		admin.GET("/export/rewards", middleware.RequirePermission(models.PermissionExportsRead), exportController.ExportRewards)
+
+		admin.GET("/audit", middleware.RequirePermission(models.PermissionAuditRead), auditController.ListEntries)
+		admin.GET("/audit/verify", middleware.RequirePermission(models.PermissionAuditRead), auditController.VerifyChain)
+
+		admin.GET("/terms", middleware.RequirePermission(models.PermissionTermsManage), termsController.ListTerms)
+		admin.POST("/terms", middleware.RequirePermission(models.PermissionTermsManage), termsController.PublishTerms)
+	}
+
+	campaigns := admin.Group("/")
+	campaigns.Use(middleware.RequirePermission(models.PermissionCampaignsManage))
 	{
-		authorized.POST("/referral_code", userController.CreateReferralCode)
-		authorized.DELETE("/referral_code", userController.DeleteReferralCode)
-		authorized.GET("/referrals", userController.GetReferrals)
+		campaigns.POST("/campaigns", campaignController.CreateCampaign)
+		campaigns.GET("/campaigns", campaignController.ListCampaigns)
+		campaigns.GET("/campaigns/:id", campaignController.GetCampaign)
+		campaigns.PUT("/campaigns/:id", campaignController.UpdateCampaign)
+		campaigns.DELETE("/campaigns/:id", campaignController.DeleteCampaign)
+		campaigns.GET("/campaigns/:id/stats", campaignController.GetCampaignStats)
+		campaigns.POST("/campaigns/:id/code_batches", campaignController.GenerateCodes)
+		campaigns.GET("/code_batches/:id", campaignController.GetCodeBatch)
+		campaigns.GET("/code_batches/:id/download", campaignController.DownloadCodeBatch)
+		campaigns.POST("/promo_codes/import", campaignController.ImportCodes)
 	}
 
 	log.Println("Server running on port 8080")
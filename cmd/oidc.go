@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+
+	"github.com/serlenario/referral-system/internal/config"
+	"github.com/serlenario/referral-system/internal/oidc"
+)
+
+func oidcProviders(configs []config.OIDCProvider) []*oidc.Provider {
+	providers := make([]*oidc.Provider, 0, len(configs))
+	for _, cfg := range configs {
+		if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+			log.Fatalf("identity provider %q needs an issuer, client ID and redirect URL", cfg.Name)
+		}
+
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         cfg.Name,
+			Issuer:       cfg.Issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       cfg.Scopes,
+		}))
+	}
+	return providers
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/serlenario/referral-system/internal/config"
+	"github.com/serlenario/referral-system/internal/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newPasswordValidator(cfg config.PasswordPolicy, hashing config.PasswordHashing) (*password.Validator, error) {
+	policy, err := password.NewPolicy(cfg.MinLength, cfg.MaxLength, cfg.MinCharClasses, cfg.BannedFile)
+	if err != nil {
+		return nil, err
+	}
+	// bcrypt refuses longer passwords, which would fail at registration
+	// instead of being reported as a policy violation.
+	if hashing.Algorithm == "bcrypt" {
+		policy.MaxBytes = password.BcryptMaxBytes
+	}
+
+	var breach password.BreachChecker
+	switch cfg.BreachProvider {
+	case "off":
+	case "file":
+		if cfg.BreachFile == "" {
+			return nil, fmt.Errorf("PASSWORD_BREACH_FILE is required for the file breach provider")
+		}
+		breach = password.NewBreachChecker(&password.FileRangeProvider{Path: cfg.BreachFile})
+	case "api":
+		breach = password.NewBreachChecker(password.NewHTTPRangeProvider(cfg.BreachAPIURL))
+	default:
+		return nil, fmt.Errorf("unknown password breach provider %q", cfg.BreachProvider)
+	}
+
+	return password.NewValidator(policy, breach), nil
+}
+
+func newPasswordHasher(cfg config.PasswordHashing) (*password.MultiHasher, error) {
+	if cfg.Argon2Memory < 1 || cfg.Argon2Time < 1 || cfg.Argon2Threads < 1 || cfg.Argon2Threads > 255 {
+		return nil, fmt.Errorf("invalid argon2 parameters")
+	}
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	argon := password.Argon2id{
+		Memory:  uint32(cfg.Argon2Memory),
+		Time:    uint32(cfg.Argon2Time),
+		Threads: uint8(cfg.Argon2Threads),
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+	bcryptHasher := password.Bcrypt{Cost: cfg.BcryptCost}
+
+	switch cfg.Algorithm {
+	case "argon2id":
+		return password.NewMultiHasher(argon, bcryptHasher), nil
+	case "bcrypt":
+		return password.NewMultiHasher(bcryptHasher, argon), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", cfg.Algorithm)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/serlenario/referral-system/internal/config"
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+	"github.com/serlenario/referral-system/internal/pii"
+	"gorm.io/gorm"
+)
+
+// piiColumns lists every column of a field tagged with serializer:pii.
+var piiColumns = []pii.Column{
+	{Table: "users", Name: "email", IndexColumn: "email_index", Normalize: emailpolicy.Normalize, Unique: true},
+	{Table: "email_changes", Name: "new_email"},
+	{Table: "user_identities", Name: "email"},
+	{Table: "sessions", Name: "ip"},
+	{Table: "terms_acceptances", Name: "ip"},
+	{Table: "audit_entries", Name: "ip", IndexColumn: "ip_index"},
+	{Table: "audit_entries", Name: "user_agent"},
+	{Table: "audit_entries", Name: "before"},
+	{Table: "audit_entries", Name: "after"},
+}
+
+func newKeyring(cfg config.PIIEncryption) (*pii.Keyring, error) {
+	keys, ids, err := pii.ParseKeys(strings.NewReader(cfg.Keys))
+	if err != nil {
+		return nil, fmt.Errorf("PII_KEYS: %w", err)
+	}
+
+	if cfg.KeysFile != "" {
+		fileKeys, fileIDs, err := pii.ParseKeysFile(cfg.KeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("PII_KEYS_FILE: %w", err)
+		}
+		for _, id := range fileIDs {
+			if _, dup := keys[id]; dup {
+				return nil, fmt.Errorf("key %q is configured twice", id)
+			}
+			keys[id] = fileKeys[id]
+			ids = append(ids, id)
+		}
+	}
+
+	active := cfg.ActiveKey
+	if active == "" && len(ids) > 0 {
+		active = ids[len(ids)-1]
+	}
+	if len(keys) > 0 && cfg.IndexKey == config.DefaultPIIIndexKey {
+		return nil, errors.New("PII_INDEX_KEY must be changed from its default when encryption is enabled")
+	}
+	return pii.NewKeyring(keys, active, []byte(cfg.IndexKey))
+}
+
+// rotatePIIKeys implements the rotate-pii-keys command:
+//
+//	go run ./cmd rotate-pii-keys [-batch 500]
+//
+// It re-encrypts every stored value that is in plaintext or encrypted with a
+// key other than the active one, fills in missing blind indexes, and prints
+// a report per column as JSON. Accounts whose addresses are aliases of each
+// other are listed as collisions and keep their old index; the command then
+// exits with status 1 so they can be merged or changed and it can be run
+// again. A column that fails does not stop the others.
+func rotatePIIKeys(db *gorm.DB, keyring *pii.Keyring, args []string) int {
+	flags := flag.NewFlagSet("rotate-pii-keys", flag.ExitOnError)
+	batch := flags.Int("batch", 500, "rows to rewrite per transaction")
+	_ = flags.Parse(args)
+
+	if *batch < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var reports []*pii.RotateReport
+	status := 0
+	for _, column := range piiColumns {
+		report, err := keyring.Rotate(db, column, *batch)
+		reports = append(reports, report)
+		if err != nil {
+			log.Printf("rotation of %s.%s failed: %v", column.Table, column.Name, err)
+			status = 1
+			continue
+		}
+		if len(report.Collisions) > 0 {
+			log.Printf("%s.%s: %d groups of rows share an index and were not reindexed", column.Table, column.Name, len(report.Collisions))
+			status = 1
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(reports); err != nil {
+		log.Printf("failed to write report: %v", err)
+		return 1
+	}
+	return status
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+// purgeInterval is how often the server erases accounts whose deletion grace
+// period has ended.
+const purgeInterval = time.Hour
+
+// purgeAccounts implements the purge-accounts command:
+//
+//	go run ./cmd purge-accounts
+//
+// It erases every account that is due, e.g. from a cron job when the server
+// itself is not running.
+func purgeAccounts(privacyService services.PrivacyService) int {
+	total := 0
+	for {
+		purged, err := privacyService.PurgeDueAccounts()
+		total += purged
+		if err != nil {
+			log.Printf("purge failed after %d accounts: %v", total, err)
+			return 1
+		}
+		if purged == 0 {
+			break
+		}
+	}
+	log.Printf("purged %d accounts", total)
+	return 0
+}
+
+// runAccountPurger erases due accounts in the background while the server runs.
+func runAccountPurger(privacyService services.PrivacyService) {
+	for {
+		purged, err := privacyService.PurgeDueAccounts()
+		if err != nil {
+			log.Printf("account purge: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("purged %d accounts", purged)
+		}
+		time.Sleep(purgeInterval)
+	}
+}
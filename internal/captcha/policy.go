@@ -0,0 +1,125 @@
+package captcha
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/ratelimit"
+)
+
+// Modes decide when a request has to present a CAPTCHA.
+const (
+	ModeOff    = "off"
+	ModeAlways = "always"
+	ModeRisk   = "risk"
+)
+
+var (
+	ErrCaptchaRequired    = errors.New("captcha required")
+	ErrCaptchaInvalid     = errors.New("captcha is invalid or has expired, please solve it again")
+	ErrCaptchaUnavailable = errors.New("captcha could not be verified, please try again later")
+)
+
+// Signals describe the request being checked.
+type Signals struct {
+	IP        string
+	UserAgent string
+}
+
+// Policy requires a valid CAPTCHA always or, in risk mode, only once a
+// request looks automated: it carries no user agent, or its client has made
+// more unverified attempts than allowed by the signup limit. Attempts with a
+// valid token are not counted, so solving a CAPTCHA never raises the count.
+// The count is kept per IPv4 address or IPv6 /64 network, since a single
+// client usually holds a whole /64, in the rate limit store, so it is shared
+// between instances like the rate limits.
+type Policy struct {
+	verifier Verifier
+	mode     string
+	store    ratelimit.Store
+	signups  ratelimit.Limit
+}
+
+func NewPolicy(verifier Verifier, mode string, store ratelimit.Store, signups ratelimit.Limit) (*Policy, error) {
+	switch mode {
+	case ModeOff, ModeAlways, ModeRisk:
+	default:
+		return nil, fmt.Errorf("unknown captcha mode %q", mode)
+	}
+	if mode != ModeOff && verifier == nil {
+		return nil, fmt.Errorf("captcha mode %q needs a verifier", mode)
+	}
+	return &Policy{verifier: verifier, mode: mode, store: store, signups: signups}, nil
+}
+
+// Check returns nil if the request may go ahead: either token is valid, or
+// no CAPTCHA is required for it. signals.IP has to be the client IP as
+// resolved through the trusted proxies.
+func (p *Policy) Check(token string, signals Signals) error {
+	if p.mode == ModeOff {
+		return nil
+	}
+
+	err := ErrCaptchaRequired
+	if token != "" {
+		if err = p.verify(token, signals.IP); err == nil {
+			return nil
+		}
+	}
+	if p.mode == ModeAlways || p.risky(signals) {
+		return err
+	}
+	return nil
+}
+
+func (p *Policy) verify(token, remoteIP string) error {
+	ok, err := p.verifier.Verify(token, remoteIP)
+	if err != nil {
+		log.Printf("captcha verification failed: %v", err)
+		return ErrCaptchaUnavailable
+	}
+	if !ok {
+		return ErrCaptchaInvalid
+	}
+	return nil
+}
+
+// risky counts an attempt without a valid token and reports whether the
+// request has to present one.
+func (p *Policy) risky(signals Signals) bool {
+	if signals.UserAgent == "" {
+		return true
+	}
+	if !p.signups.Enabled() {
+		return false
+	}
+	result, err := p.store.Take("captcha:"+clientKey(signals.IP), p.signups, time.Now())
+	if err != nil {
+		// Asking for a CAPTCHA is safer than letting the request through
+		// unchecked.
+		log.Printf("captcha risk check failed: %v", err)
+		return true
+	}
+	return !result.Allowed
+}
+
+// clientKey identifies the client an IP belongs to: the address itself for
+// IPv4, its /64 network for IPv6.
+func clientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, err := addr.Prefix(64)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
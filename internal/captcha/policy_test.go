@@ -0,0 +1,183 @@
+package captcha
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/ratelimit"
+)
+
+// fakeVerifier accepts the token "valid" and fails with err if set.
+type fakeVerifier struct {
+	err   error
+	calls int
+}
+
+func (v *fakeVerifier) Verify(token, remoteIP string) (bool, error) {
+	v.calls++
+	if v.err != nil {
+		return false, v.err
+	}
+	return token == "valid", nil
+}
+
+// failingStore fails every Take, like an unreachable database.
+type failingStore struct{}
+
+func (failingStore) Take(string, ratelimit.Limit, time.Time) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store unavailable")
+}
+
+var browser = Signals{IP: "203.0.113.7", UserAgent: "Mozilla/5.0"}
+
+func newTestPolicy(t *testing.T, mode string, verifier Verifier, store ratelimit.Store) *Policy {
+	t.Helper()
+	if store == nil {
+		store = ratelimit.NewMemoryStore()
+	}
+	policy, err := NewPolicy(verifier, mode, store, ratelimit.Limit{Requests: 2, Period: time.Hour})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return policy
+}
+
+func TestNewPolicy(t *testing.T) {
+	if _, err := NewPolicy(nil, "sometimes", nil, ratelimit.Limit{}); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	if _, err := NewPolicy(nil, ModeRisk, nil, ratelimit.Limit{}); err == nil {
+		t.Error("risk mode without a verifier accepted")
+	}
+	if _, err := NewPolicy(nil, ModeOff, nil, ratelimit.Limit{}); err != nil {
+		t.Errorf("off mode without a verifier: %v", err)
+	}
+}
+
+func TestPolicyOff(t *testing.T) {
+	verifier := &fakeVerifier{}
+	policy := newTestPolicy(t, ModeOff, verifier, nil)
+
+	for i := 0; i < 5; i++ {
+		if err := policy.Check("", Signals{IP: "203.0.113.7"}); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	if err := policy.Check("forged", browser); err != nil {
+		t.Fatalf("token in off mode: %v", err)
+	}
+	if verifier.calls != 0 {
+		t.Fatalf("verifier called %d times in off mode", verifier.calls)
+	}
+}
+
+func TestPolicyAlways(t *testing.T) {
+	policy := newTestPolicy(t, ModeAlways, &fakeVerifier{}, nil)
+
+	tests := []struct {
+		token string
+		want  error
+	}{
+		{"", ErrCaptchaRequired},
+		{"forged", ErrCaptchaInvalid},
+		{"valid", nil},
+	}
+	for _, tt := range tests {
+		if err := policy.Check(tt.token, browser); !errors.Is(err, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.token, err, tt.want)
+		}
+	}
+}
+
+func TestPolicyAlwaysVerifierDown(t *testing.T) {
+	policy := newTestPolicy(t, ModeAlways, &fakeVerifier{err: errors.New("timeout")}, nil)
+	if err := policy.Check("valid", browser); !errors.Is(err, ErrCaptchaUnavailable) {
+		t.Fatalf("Check = %v, want ErrCaptchaUnavailable", err)
+	}
+}
+
+func TestPolicyRiskRequiresUserAgent(t *testing.T) {
+	policy := newTestPolicy(t, ModeRisk, &fakeVerifier{}, nil)
+
+	if err := policy.Check("", Signals{IP: "203.0.113.7"}); !errors.Is(err, ErrCaptchaRequired) {
+		t.Fatalf("Check without user agent = %v, want ErrCaptchaRequired", err)
+	}
+	if err := policy.Check("valid", Signals{IP: "203.0.113.7"}); err != nil {
+		t.Fatalf("Check with a solved CAPTCHA: %v", err)
+	}
+}
+
+func TestPolicyRiskCountsUnverifiedAttempts(t *testing.T) {
+	policy := newTestPolicy(t, ModeRisk, &fakeVerifier{}, nil)
+
+	for i := 0; i < 2; i++ {
+		if err := policy.Check("", browser); err != nil {
+			t.Fatalf("attempt %d within the limit: %v", i+1, err)
+		}
+	}
+	if err := policy.Check("", browser); !errors.Is(err, ErrCaptchaRequired) {
+		t.Fatalf("attempt over the limit = %v, want ErrCaptchaRequired", err)
+	}
+	if err := policy.Check("forged", browser); !errors.Is(err, ErrCaptchaInvalid) {
+		t.Fatalf("forged token over the limit = %v, want ErrCaptchaInvalid", err)
+	}
+	if err := policy.Check("valid", browser); err != nil {
+		t.Fatalf("solved CAPTCHA over the limit: %v", err)
+	}
+
+	other := Signals{IP: "198.51.100.1", UserAgent: "Mozilla/5.0"}
+	if err := policy.Check("", other); err != nil {
+		t.Fatalf("another client: %v", err)
+	}
+}
+
+func TestPolicyRiskDoesNotCountSolvedCaptchas(t *testing.T) {
+	policy := newTestPolicy(t, ModeRisk, &fakeVerifier{}, nil)
+
+	for i := 0; i < 5; i++ {
+		if err := policy.Check("valid", browser); err != nil {
+			t.Fatalf("solved CAPTCHA %d: %v", i+1, err)
+		}
+	}
+	if err := policy.Check("", browser); err != nil {
+		t.Fatalf("first attempt without a token after solved ones: %v", err)
+	}
+}
+
+func TestPolicyRiskGroupsIPv6Networks(t *testing.T) {
+	policy := newTestPolicy(t, ModeRisk, &fakeVerifier{}, nil)
+
+	ips := []string{"2001:db8:1:2::1", "2001:db8:1:2::2", "2001:db8:1:2:ffff::3"}
+	var err error
+	for _, ip := range ips {
+		err = policy.Check("", Signals{IP: ip, UserAgent: "Mozilla/5.0"})
+	}
+	if !errors.Is(err, ErrCaptchaRequired) {
+		t.Fatalf("third address of one /64 = %v, want ErrCaptchaRequired", err)
+	}
+	if err := policy.Check("", Signals{IP: "2001:db8:1:3::1", UserAgent: "Mozilla/5.0"}); err != nil {
+		t.Fatalf("another /64: %v", err)
+	}
+}
+
+func TestPolicyRiskStoreDown(t *testing.T) {
+	policy := newTestPolicy(t, ModeRisk, &fakeVerifier{}, failingStore{})
+	if err := policy.Check("", browser); !errors.Is(err, ErrCaptchaRequired) {
+		t.Fatalf("Check with the store down = %v, want ErrCaptchaRequired", err)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7":          "203.0.113.7",
+		"::ffff:203.0.113.7":   "203.0.113.7",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1:2::/64",
+		"not an ip":            "not an ip",
+	}
+	for ip, want := range tests {
+		if got := clientKey(ip); got != want {
+			t.Errorf("clientKey(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package captcha verifies CAPTCHA tokens solved by clients and decides when
+// a request has to present one.
+package captcha
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Verifier checks a token solved by the client. remoteIP is passed on to
+// the provider as an additional signal and may be empty.
+type Verifier interface {
+	Verify(token, remoteIP string) (bool, error)
+}
+
+// HTTPVerifier checks tokens with a siteverify endpoint as offered by
+// hCaptcha, Cloudflare Turnstile and reCAPTCHA: the token is posted as a form
+// together with the secret, and the JSON answer says whether it is valid.
+type HTTPVerifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func NewHTTPVerifier(verifyURL, secret string) *HTTPVerifier {
+	return &HTTPVerifier{URL: verifyURL, Secret: secret, Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+type siteverifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func (v *HTTPVerifier) Verify(token, remoteIP string) (bool, error) {
+	form := url.Values{"secret": {v.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	resp, err := v.Client.PostForm(v.URL, form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("captcha verification returned %s", resp.Status)
+	}
+
+	var result siteverifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("invalid captcha verification response: %w", err)
+	}
+	// A wrong secret is a configuration problem, not a bad token.
+	for _, code := range result.ErrorCodes {
+		if strings.HasPrefix(code, "invalid-input-secret") || code == "missing-input-secret" {
+			return false, fmt.Errorf("captcha verification rejected the secret: %s", code)
+		}
+	}
+	return result.Success, nil
+}
@@ -0,0 +1,91 @@
+package captcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testSecret = "test-secret"
+
+// newSiteverify starts a siteverify stub that accepts the token "valid" with
+// testSecret and answers like hCaptcha otherwise.
+func newSiteverify(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.PostForm.Get("secret") != testSecret:
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-secret"]}`))
+		case r.PostForm.Get("response") == "valid" && r.PostForm.Get("remoteip") == "203.0.113.7":
+			w.Write([]byte(`{"success": true}`))
+		default:
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPVerifierValidToken(t *testing.T) {
+	server := newSiteverify(t)
+	ok, err := NewHTTPVerifier(server.URL, testSecret).Verify("valid", "203.0.113.7")
+	if err != nil || !ok {
+		t.Fatalf("Verify = %v, %v; want true, nil", ok, err)
+	}
+}
+
+func TestHTTPVerifierInvalidToken(t *testing.T) {
+	server := newSiteverify(t)
+	ok, err := NewHTTPVerifier(server.URL, testSecret).Verify("forged", "203.0.113.7")
+	if err != nil || ok {
+		t.Fatalf("Verify = %v, %v; want false, nil", ok, err)
+	}
+}
+
+func TestHTTPVerifierBadSecret(t *testing.T) {
+	server := newSiteverify(t)
+	ok, err := NewHTTPVerifier(server.URL, "wrong").Verify("valid", "203.0.113.7")
+	if err == nil || ok {
+		t.Fatalf("Verify = %v, %v; want an error", ok, err)
+	}
+}
+
+func TestHTTPVerifierNon200(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ok, err := NewHTTPVerifier(server.URL, testSecret).Verify("valid", "")
+	if err == nil || ok {
+		t.Fatalf("Verify = %v, %v; want an error", ok, err)
+	}
+}
+
+func TestHTTPVerifierMalformedJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html>not json</html>`))
+	}))
+	defer server.Close()
+
+	ok, err := NewHTTPVerifier(server.URL, testSecret).Verify("valid", "")
+	if err == nil || ok {
+		t.Fatalf("Verify = %v, %v; want an error", ok, err)
+	}
+}
+
+func TestHTTPVerifierUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	ok, err := NewHTTPVerifier(url, testSecret).Verify("valid", "")
+	if err == nil || ok {
+		t.Fatalf("Verify = %v, %v; want an error", ok, err)
+	}
+}
@@ -3,8 +3,13 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/serlenario/referral-system/internal/notify"
+	"github.com/serlenario/referral-system/internal/ratelimit"
 )
 
 type Config struct {
@@ -14,6 +19,158 @@ type Config struct {
 	DBPassword string
 	DBName     string
 	JWTSecret  string
+
+	LeaderboardCacheTTL time.Duration
+
+	EmailMaskVisibleChars int
+	EmailMaskDomain       bool
+
+	LoginPolicy LoginPolicy
+
+	PasswordPolicy  PasswordPolicy
+	PasswordHashing PasswordHashing
+
+	PII PIIEncryption
+
+	EmailPolicy EmailPolicy
+
+	Captcha Captcha
+
+	RateLimits RateLimits
+
+	// TrustedProxies lists the IPs and CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header is believed. Without any, the client IP is the
+	// address of the connection.
+	TrustedProxies []string
+
+	OIDCProviders []OIDCProvider
+
+	// Notify selects how emails to users (lockout notices, login links) are
+	// delivered.
+	Notify notify.Config
+
+	// MagicLinkURL is where login links point; the token is appended as the
+	// token query parameter.
+	MagicLinkURL string
+	MagicLinkTTL time.Duration
+
+	// EmailChangeURL is where links confirming a new email address point,
+	// with the token appended like MagicLinkURL.
+	EmailChangeURL string
+	EmailChangeTTL time.Duration
+
+	// AccountDeletionGrace is how long a user can still cancel the deletion
+	// of their account by logging in before their data is erased.
+	AccountDeletionGrace time.Duration
+}
+
+// RateLimits holds the per-route limits. Store is "memory" for a single
+// instance or "postgres" to share the limits between instances.
+type RateLimits struct {
+	Store                string
+	Register             ratelimit.Limit
+	Login                ratelimit.Limit
+	LoginPerEmail        ratelimit.Limit
+	RegisterWithReferral ratelimit.Limit
+	ReferralCodeLookup   ratelimit.Limit
+	MagicLink            ratelimit.Limit
+	DataExport           ratelimit.Limit
+	EmailChange          ratelimit.Limit
+	Authenticated        ratelimit.Limit
+}
+
+// LongestPeriod is how long a rate limit bucket can matter after its last use.
+func (r RateLimits) LongestPeriod() time.Duration {
+	var longest time.Duration
+	for _, limit := range []ratelimit.Limit{r.Register, r.Login, r.LoginPerEmail, r.RegisterWithReferral, r.ReferralCodeLookup, r.MagicLink, r.DataExport, r.EmailChange, r.Authenticated} {
+		longest = max(longest, limit.Period)
+	}
+	return longest
+}
+
+// PasswordPolicy configures the requirements for new passwords.
+// BreachProvider is "off", "file" (a local sorted hash file at BreachFile)
+// or "api" (a Pwned Passwords compatible range API at BreachAPIURL).
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	MinCharClasses int
+	BannedFile     string
+	BreachProvider string
+	BreachFile     string
+	BreachAPIURL   string
+}
+
+// PasswordHashing selects the algorithm for new password hashes, "argon2id"
+// or "bcrypt". Hashes made with the other algorithm or weaker parameters are
+// replaced the next time their owner logs in.
+type PasswordHashing struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Memory  int
+	Argon2Time    int
+	Argon2Threads int
+}
+
+// DefaultPIIIndexKey is the placeholder PII_INDEX_KEY. It is refused once
+// encryption is enabled, since anyone could then compute the blind indexes.
+const DefaultPIIIndexKey = "your_pii_index_key"
+
+// PIIEncryption configures the encryption of personal data at rest. Keys
+// and the file at KeysFile list master keys as id:base64key; new values are
+// encrypted with ActiveKey, by default the last key listed. IndexKey keys the
+// blind index used to look up users by email.
+type PIIEncryption struct {
+	Keys      string
+	KeysFile  string
+	ActiveKey string
+	IndexKey  string
+}
+
+// EmailPolicy configures how signups from disposable domains are handled:
+// DisposableAction is "reject", "flag" or "off". DisposableFile extends the
+// built-in list of domains and is re-read when it changes, checked at most
+// once per ReloadInterval.
+type EmailPolicy struct {
+	DisposableAction string
+	DisposableFile   string
+	ReloadInterval   time.Duration
+}
+
+// Captcha configures CAPTCHA checks on signup with a referral code. Mode is
+// "off", "always" or "risk"; in risk mode a CAPTCHA is only required from
+// clients without a user agent and from IPs exceeding RiskSignupsPerIP.
+// Tokens are checked with the siteverify endpoint at VerifyURL.
+type Captcha struct {
+	Mode             string
+	VerifyURL        string
+	Secret           string
+	RiskSignupsPerIP ratelimit.Limit
+}
+
+// OIDCProvider configures an OpenID Connect identity provider. Providers are
+// listed in OIDC_PROVIDERS and configured with OIDC_<NAME>_* variables.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// LoginPolicy controls how failed logins are throttled. Failures are counted
+// per account and per client IP within FailureWindow. Every failed attempt on
+// an account delays the next one by BackoffBase, doubling up to BackoffMax.
+// Reaching the maximum locks the account or IP for LockoutDuration; IPs get
+// no backoff before that since many users may share one.
+type LoginPolicy struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	FailureWindow      time.Duration
+	BackoffBase        time.Duration
+	BackoffMax         time.Duration
+	LockoutDuration    time.Duration
 }
 
 func LoadConfig() *Config {
@@ -29,12 +186,190 @@ func LoadConfig() *Config {
 		DBPassword: getEnv("DB_PASSWORD", "password"),
 		DBName:     getEnv("DB_NAME", "referral_db"),
 		JWTSecret:  getEnv("JWT_SECRET", "your_jwt_secret"),
+
+		LeaderboardCacheTTL: getEnvDuration("LEADERBOARD_CACHE_TTL", 5*time.Minute),
+
+		EmailMaskVisibleChars: getEnvInt("EMAIL_MASK_VISIBLE_CHARS", 2),
+		EmailMaskDomain:       getEnvBool("EMAIL_MASK_DOMAIN", false),
+
+		LoginPolicy: LoginPolicy{
+			MaxAccountFailures: getEnvInt("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+			MaxIPFailures:      getEnvInt("LOGIN_MAX_IP_FAILURES", 50),
+			FailureWindow:      getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+			BackoffBase:        getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+			BackoffMax:         getEnvDuration("LOGIN_BACKOFF_MAX", time.Minute),
+			LockoutDuration:    getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		},
+
+		PasswordPolicy: PasswordPolicy{
+			MinLength:      getEnvInt("PASSWORD_MIN_LENGTH", 10),
+			MaxLength:      getEnvInt("PASSWORD_MAX_LENGTH", 128),
+			MinCharClasses: getEnvInt("PASSWORD_MIN_CHAR_CLASSES", 2),
+			BannedFile:     getEnv("PASSWORD_BANNED_FILE", ""),
+			BreachProvider: getEnv("PASSWORD_BREACH_PROVIDER", "off"),
+			BreachFile:     getEnv("PASSWORD_BREACH_FILE", ""),
+			BreachAPIURL:   getEnv("PASSWORD_BREACH_API_URL", "https://api.pwnedpasswords.com/range"),
+		},
+
+		PasswordHashing: PasswordHashing{
+			Algorithm:     getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+			BcryptCost:    getEnvInt("PASSWORD_BCRYPT_COST", 12),
+			Argon2Memory:  getEnvInt("PASSWORD_ARGON2_MEMORY", 19456),
+			Argon2Time:    getEnvInt("PASSWORD_ARGON2_TIME", 2),
+			Argon2Threads: getEnvInt("PASSWORD_ARGON2_THREADS", 1),
+		},
+
+		PII: PIIEncryption{
+			Keys:      getEnv("PII_KEYS", ""),
+			KeysFile:  getEnv("PII_KEYS_FILE", ""),
+			ActiveKey: getEnv("PII_ACTIVE_KEY", ""),
+			IndexKey:  getEnv("PII_INDEX_KEY", DefaultPIIIndexKey),
+		},
+
+		EmailPolicy: EmailPolicy{
+			DisposableAction: getEnv("EMAIL_DISPOSABLE_ACTION", "reject"),
+			DisposableFile:   getEnv("EMAIL_DISPOSABLE_FILE", ""),
+			ReloadInterval:   getEnvDuration("EMAIL_DISPOSABLE_RELOAD", time.Minute),
+		},
+
+		Captcha: Captcha{
+			Mode:             getEnv("CAPTCHA_MODE", "off"),
+			VerifyURL:        getEnv("CAPTCHA_VERIFY_URL", "https://api.hcaptcha.com/siteverify"),
+			Secret:           getEnv("CAPTCHA_SECRET", ""),
+			RiskSignupsPerIP: getEnvRateLimit("CAPTCHA_RISK_SIGNUPS_PER_IP", ratelimit.Limit{Requests: 2, Period: time.Hour}),
+		},
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		RateLimits: RateLimits{
+			Store:                getEnv("RATE_LIMIT_STORE", "memory"),
+			Register:             getEnvRateLimit("RATE_LIMIT_REGISTER", ratelimit.Limit{Requests: 5, Period: time.Hour}),
+			Login:                getEnvRateLimit("RATE_LIMIT_LOGIN", ratelimit.Limit{Requests: 20, Period: time.Minute}),
+			LoginPerEmail:        getEnvRateLimit("RATE_LIMIT_LOGIN_PER_EMAIL", ratelimit.Limit{Requests: 10, Period: time.Minute}),
+			RegisterWithReferral: getEnvRateLimit("RATE_LIMIT_REGISTER_WITH_REFERRAL", ratelimit.Limit{Requests: 5, Period: time.Hour}),
+			ReferralCodeLookup:   getEnvRateLimit("RATE_LIMIT_REFERRAL_CODE_LOOKUP", ratelimit.Limit{Requests: 30, Period: time.Minute}),
+			MagicLink:            getEnvRateLimit("RATE_LIMIT_MAGIC_LINK", ratelimit.Limit{Requests: 5, Period: time.Hour}),
+			DataExport:           getEnvRateLimit("RATE_LIMIT_DATA_EXPORT", ratelimit.Limit{Requests: 5, Period: time.Hour}),
+			EmailChange:          getEnvRateLimit("RATE_LIMIT_EMAIL_CHANGE", ratelimit.Limit{Requests: 5, Period: time.Hour}),
+			Authenticated:        getEnvRateLimit("RATE_LIMIT_AUTHENTICATED", ratelimit.Limit{Requests: 300, Period: time.Minute}),
+		},
+
+		OIDCProviders: loadOIDCProviders(),
+
+		Notify: notify.Config{
+			SMTPAddr:     getEnv("SMTP_ADDR", ""),
+			SMTPFrom:     getEnv("SMTP_FROM", "no-reply@localhost"),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			WebhookURL:   getEnv("NOTIFY_WEBHOOK_URL", ""),
+		},
+
+		MagicLinkURL: getEnv("MAGIC_LINK_URL", "http://localhost:8080/login/magic_link/verify"),
+		MagicLinkTTL: getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute),
+
+		EmailChangeURL: getEnv("EMAIL_CHANGE_URL", "http://localhost:8080/email/confirm"),
+		EmailChangeTTL: getEnvDuration("EMAIL_CHANGE_TTL", 24*time.Hour),
+
+		AccountDeletionGrace: getEnvDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour),
 	}
 }
 
+// wellKnownIssuers saves configuring the issuer of common providers.
+var wellKnownIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+}
+
+func loadOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProvider{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", wellKnownIssuers[name]),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", ""),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		})
+	}
+	return providers
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration %q for %s, using default %s", value, key, fallback)
+		return fallback
+	}
+	return duration
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer %q for %s, using default %d", value, key, fallback)
+		return fallback
+	}
+	return number
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean %q for %s, using default %t", value, key, fallback)
+		return fallback
+	}
+	return flag
+}
+
+func getEnvRateLimit(key string, fallback ratelimit.Limit) ratelimit.Limit {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Printf("Invalid rate limit %q for %s, using default %s", value, key, fallback)
+		return fallback
+	}
+	return limit
+}
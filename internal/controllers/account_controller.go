@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/password"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type AccountController struct {
+	AccountService services.AccountService
+	PrivacyService services.PrivacyService
+}
+
+func NewAccountController(accountService services.AccountService, privacyService services.PrivacyService) *AccountController {
+	return &AccountController{AccountService: accountService, PrivacyService: privacyService}
+}
+
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" binding:"omitempty,max=32"`
+}
+
+type ChangePasswordRequest struct {
+	// CurrentPassword may be left out by accounts that have no password yet.
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ChangeEmailRequest struct {
+	Email           string `json:"email" binding:"required,email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type DeleteAccountRequest struct {
+	// CurrentPassword may be left out by accounts that have no password.
+	CurrentPassword string `json:"current_password"`
+}
+
+type DeleteAccountResponse struct {
+	DeletionDueAt time.Time `json:"deletion_due_at"`
+}
+
+// GetProfile godoc
+// @Summary Get own account
+// @Description Retrieve the authenticated user's account, whether it has a password and any email change waiting for confirmation
+// @Tags account
+// @Produce json
+// @Success 200 {object} services.Profile
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /me [get]
+func (ac *AccountController) GetProfile(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	profile, err := ac.AccountService.GetProfile(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateProfile godoc
+// @Summary Update own account
+// @Description Change profile fields of the authenticated user. Fields left out are not changed.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param profile body UpdateProfileRequest true "Profile"
+// @Success 200 {object} services.Profile
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /me [patch]
+func (ac *AccountController) UpdateProfile(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req UpdateProfileRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	profile, err := ac.AccountService.UpdateProfile(userID, services.ProfileUpdate{DisplayName: req.DisplayName}, requestMeta(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Set a new password, confirming the current one. All other sessions are logged out. Accounts without a password can set one within 10 minutes of logging in with a login link or identity provider.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param password body ChangePasswordRequest true "Passwords"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /me/password [put]
+func (ac *AccountController) ChangePassword(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req ChangePasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	err := ac.AccountService.ChangePassword(userID, c.GetUint("sessionID"), req.CurrentPassword, req.NewPassword, requestMeta(c))
+	if err != nil {
+		if errors.Is(err, password.ErrWeakPassword) || errors.Is(err, password.ErrBreachedPassword) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		accountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{Message: "Password changed"})
+}
+
+// ChangeEmail godoc
+// @Summary Change email address
+// @Description Send a confirmation link to the new address. The account switches to it once the link is followed; referral codes and referrals are kept. Accounts with a password have to confirm it; accounts without one must have logged in within the last 10 minutes.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param email body ChangeEmailRequest true "New Email"
+// @Success 202 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /me/email [post]
+func (ac *AccountController) ChangeEmail(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req ChangeEmailRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := ac.AccountService.RequestEmailChange(userID, c.GetUint("sessionID"), req.Email, req.CurrentPassword, requestMeta(c)); err != nil {
+		accountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.SuccessResponse{Message: "Confirmation link sent to the new address"})
+}
+
+// ConfirmEmail godoc
+// @Summary Confirm new email address
+// @Description Follow the link sent to the new address to switch the account to it
+// @Tags account
+// @Produce json
+// @Param token query string true "Token from the confirmation link"
+// @Success 200 {object} models.User
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /email/confirm [get]
+func (ac *AccountController) ConfirmEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "token is required"})
+		return
+	}
+
+	user, err := ac.AccountService.ConfirmEmailChange(token, requestMeta(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidEmailChange) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		accountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ExportData godoc
+// @Summary Export own data
+// @Description Download everything stored about the authenticated user as JSON: profile, identities, referrals, rewards, status history, sessions, API keys and audit entries
+// @Tags account
+// @Produce json
+// @Success 200 {object} models.AccountExport
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /me/export [get]
+func (ac *AccountController) ExportData(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	export, err := ac.PrivacyService.Export(userID, requestMeta(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("account-%d-%s.json", userID, export.ExportedAt.Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.JSON(http.StatusOK, export)
+}
+
+// DeleteAccount godoc
+// @Summary Delete own account
+// @Description Log out everywhere and schedule the account for deletion. Personal data is erased once the grace period ends; logging in before then cancels the deletion. Accounts with a password have to confirm it; accounts without one must have logged in within the last 10 minutes.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param confirmation body DeleteAccountRequest true "Confirmation"
+// @Success 202 {object} DeleteAccountResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /me [delete]
+func (ac *AccountController) DeleteAccount(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req DeleteAccountRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user, err := ac.PrivacyService.RequestDeletion(userID, c.GetUint("sessionID"), req.CurrentPassword, requestMeta(c))
+	if err != nil {
+		accountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, DeleteAccountResponse{DeletionDueAt: *user.DeletionDueAt})
+}
+
+func accountError(c *gin.Context, err error) {
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, models.ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrWrongPassword), errors.Is(err, services.ErrAccountInactive), errors.Is(err, services.ErrReauthRequired):
+		c.JSON(http.StatusForbidden, models.ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrEmailTaken):
+		c.JSON(http.StatusConflict, models.ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrSameEmail), errors.Is(err, emailpolicy.ErrDisposableEmail):
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+	}
+}
@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type AdminController struct {
+	AdminService services.AdminService
+	UserService  services.UserService
+}
+
+func NewAdminController(adminService services.AdminService, userService services.UserService) *AdminController {
+	return &AdminController{AdminService: adminService, UserService: userService}
+}
+
+type UserSearchQuery struct {
+	Email    string `form:"email"`
+	Role     string `form:"role" binding:"omitempty,oneof=user support admin"`
+	Status   string `form:"status" binding:"omitempty,oneof=active suspended banned"`
+	Page     int    `form:"page,default=1" binding:"min=1"`
+	PageSize int    `form:"page_size,default=20" binding:"min=1,max=100"`
+}
+
+type UsersResponse struct {
+	Users    []models.User `json:"users"`
+	Page     int           `json:"page"`
+	PageSize int           `json:"page_size"`
+	Total    int64         `json:"total"`
+}
+
+type SetStatusRequest struct {
+	Status    string     `json:"status" binding:"required,oneof=active suspended banned"`
+	Reason    string     `json:"reason" binding:"max=500"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type StatusHistoryResponse struct {
+	Changes []models.UserStatusChange `json:"changes"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user support admin"`
+}
+
+// SearchUsers godoc
+// @Summary Search users (admin)
+// @Description Search users by exact email, role and status
+// @Tags admin
+// @Produce json
+// @Param email query string false "Email address"
+// @Param role query string false "Role" Enums(user, support, admin)
+// @Param status query string false "Account status" Enums(active, suspended, banned)
+// @Param page query int false "Page number" minimum(1)
+// @Param page_size query int false "Page size" minimum(1) maximum(100)
+// @Success 200 {object} UsersResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users [get]
+func (ac *AdminController) SearchUsers(c *gin.Context) {
+	var query UserSearchQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	users, total, err := ac.AdminService.SearchUsers(services.UserSearchOptions{
+		Email:    query.Email,
+		Role:     query.Role,
+		Status:   query.Status,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, UsersResponse{
+		Users:    users,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	})
+}
+
+// GetUser godoc
+// @Summary Get user (admin)
+// @Description Get any user by ID
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id} [get]
+func (ac *AdminController) GetUser(c *gin.Context) {
+	userID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	user, err := ac.AdminService.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// GetUserReferrals godoc
+// @Summary Get user referrals (admin)
+// @Description Retrieve a page of the referrals made by any user. Accepts the same parameters as GET /referrals.
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Param from query string false "Created at or after (RFC3339)"
+// @Param to query string false "Created before (RFC3339)"
+// @Param status query string false "Referral status" Enums(pending, qualified, rejected)
+// @Param code query string false "Referral code used"
+// @Param sort query string false "Sort order" Enums(created_at_desc, created_at_asc)
+// @Param cursor query string false "Pagination cursor"
+// @Param limit query int false "Page size" minimum(1) maximum(100)
+// @Success 200 {object} ReferralsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/referrals [get]
+func (ac *AdminController) GetUserReferrals(c *gin.Context) {
+	userID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	listReferrals(c, ac.UserService, userID)
+}
+
+// ApproveReferral godoc
+// @Summary Approve pending referral (admin)
+// @Description Qualify a referral held for review, such as one from a signup with a flagged email address, and grant the rewards of its campaign
+// @Tags admin
+// @Produce json
+// @Param id path int true "Referral ID"
+// @Success 200 {object} models.Referral
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/referrals/{id}/approve [post]
+func (ac *AdminController) ApproveReferral(c *gin.Context) {
+	referralID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	referral, err := ac.UserService.ApproveReferral(referralID, requestMeta(c))
+	if errors.Is(err, services.ErrReferralNotPending) {
+		c.JSON(http.StatusConflict, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, referral)
+}
+
+// RevokeReferralCodes godoc
+// @Summary Revoke user's referral codes (admin)
+// @Description Delete the user's personal referral code and revoke all promo codes they own
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/referral_codes [delete]
+func (ac *AdminController) RevokeReferralCodes(c *gin.Context) {
+	userID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	revoked, err := ac.AdminService.RevokeReferralCodes(userID, requestMeta(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Message: fmt.Sprintf("Referral code deleted and %d promo codes revoked", revoked),
+	})
+}
+
+// UnlockUser godoc
+// @Summary Unlock account after failed logins (admin)
+// @Description Clear the failed login counter of an account so the user can log in again right away
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/unlock [post]
+func (ac *AdminController) UnlockUser(c *gin.Context) {
+	userID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := ac.AdminService.UnlockUser(userID, requestMeta(c)); err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{Message: "Account unlocked"})
+}
+
+// SetUserStatus godoc
+// @Summary Suspend, ban or reinstate account (admin)
+// @Description Change the status of an account. Suspended and banned users cannot log in, their existing tokens stop working and their referral codes are deactivated. A suspension may carry an expiry after which it lifts itself. Only users with a lower role than the caller's can be changed.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param status body SetStatusRequest true "Account Status"
+// @Success 200 {object} models.User
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/status [post]
+func (ac *AdminController) SetUserStatus(c *gin.Context) {
+	adminID := c.MustGet("userID").(uint)
+	userID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req SetStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user, err := ac.AdminService.SetUserStatus(adminID, userID, req.Status, req.Reason, req.ExpiresAt, requestMeta(c))
+	if errors.Is(err, services.ErrInsufficientRole) {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// GetStatusHistory godoc
+// @Summary Get account status history (admin)
+// @Description List every status change of an account with the admin who made it, newest first
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} StatusHistoryResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/status_history [get]
+func (ac *AdminController) GetStatusHistory(c *gin.Context) {
+	userID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	changes, err := ac.AdminService.GetStatusHistory(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusHistoryResponse{Changes: changes})
+}
+
+// SetUserRole godoc
+// @Summary Change user role (admin)
+// @Description Change the role of a user. Takes effect immediately, including for tokens already issued.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role body SetRoleRequest true "Role"
+// @Success 200 {object} models.User
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/role [put]
+func (ac *AdminController) SetUserRole(c *gin.Context) {
+	adminID := c.MustGet("userID").(uint)
+	userID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user, err := ac.AdminService.SetUserRole(adminID, userID, req.Role, requestMeta(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRole) || errors.Is(err, services.ErrCannotModifySelf) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
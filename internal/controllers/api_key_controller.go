@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type APIKeyController struct {
+	APIKeyService services.APIKeyService
+}
+
+func NewAPIKeyController(apiKeyService services.APIKeyService) *APIKeyController {
+	return &APIKeyController{APIKeyService: apiKeyService}
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	// Key is shown only once; only its hash is stored.
+	Key    string         `json:"key"`
+	APIKey *models.APIKey `json:"api_key"`
+}
+
+// CreateAPIKey godoc
+// @Summary Create API key
+// @Description Create a key for calling the API from another service as the authenticated user. Send it as "Authorization: Bearer <key>". Scopes can be referral_codes:read, referral_codes:write, referrals:list, leaderboard:write and any permission of the user's role. The key is returned only once.
+// @Tags api_keys
+// @Accept json
+// @Produce json
+// @Param key body CreateAPIKeyRequest true "API Key"
+// @Success 201 {object} CreateAPIKeyResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api_keys [post]
+func (kc *APIKeyController) CreateAPIKey(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req CreateAPIKeyRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	key, rawKey, err := kc.APIKeyService.Create(userID, req.Name, req.Scopes, req.ExpiresAt, requestMeta(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidScope) || errors.Is(err, services.ErrNoScopes) || errors.Is(err, services.ErrAPIKeyExpiryInPast) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{Key: rawKey, APIKey: key})
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Description List the authenticated user's API keys, including revoked and expired ones
+// @Tags api_keys
+// @Produce json
+// @Success 200 {array} models.APIKey
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api_keys [get]
+func (kc *APIKeyController) ListAPIKeys(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	keys, err := kc.APIKeyService.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke API key
+// @Description Revoke one of the authenticated user's API keys. It stops working immediately.
+// @Tags api_keys
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api_keys/{id} [delete]
+func (kc *APIKeyController) RevokeAPIKey(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	keyID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := kc.APIKeyService.Revoke(userID, keyID, requestMeta(c)); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{Message: "API key revoked"})
+}
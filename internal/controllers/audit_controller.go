@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type AuditController struct {
+	AuditService services.AuditService
+}
+
+func NewAuditController(auditService services.AuditService) *AuditController {
+	return &AuditController{AuditService: auditService}
+}
+
+type AuditQuery struct {
+	ActorID    uint      `form:"actor_id"`
+	Action     string    `form:"action"`
+	TargetType string    `form:"target_type" binding:"omitempty,oneof=user referral api_key terms"`
+	TargetID   uint      `form:"target_id"`
+	IP         string    `form:"ip" binding:"omitempty,ip"`
+	From       time.Time `form:"from"`
+	To         time.Time `form:"to"`
+	Page       int       `form:"page,default=1" binding:"min=1"`
+	PageSize   int       `form:"page_size,default=50" binding:"min=1,max=200"`
+}
+
+type AuditEntriesResponse struct {
+	Entries  []models.AuditEntry `json:"entries"`
+	Page     int                 `json:"page"`
+	PageSize int                 `json:"page_size"`
+	Total    int64               `json:"total"`
+}
+
+// ListEntries godoc
+// @Summary Query audit log (admin)
+// @Description List audit log entries, newest first
+// @Tags admin
+// @Produce json
+// @Param actor_id query int false "User who performed the action"
+// @Param action query string false "Action, e.g. auth.login_failed"
+// @Param target_type query string false "Target type" Enums(user, referral, api_key, terms)
+// @Param target_id query int false "Target ID"
+// @Param ip query string false "Client IP address"
+// @Param from query string false "Created at or after (RFC3339)"
+// @Param to query string false "Created before (RFC3339)"
+// @Param page query int false "Page number" minimum(1)
+// @Param page_size query int false "Page size" minimum(1) maximum(200)
+// @Success 200 {object} AuditEntriesResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/audit [get]
+func (ac *AuditController) ListEntries(c *gin.Context) {
+	var query AuditQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	entries, total, err := ac.AuditService.ListEntries(services.AuditListOptions{
+		ActorID:    query.ActorID,
+		Action:     query.Action,
+		TargetType: query.TargetType,
+		TargetID:   query.TargetID,
+		IP:         query.IP,
+		From:       query.From,
+		To:         query.To,
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, AuditEntriesResponse{
+		Entries:  entries,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	})
+}
+
+// VerifyChain godoc
+// @Summary Verify audit log integrity (admin)
+// @Description Recompute the hash chain of the audit log and report the first entry that was altered or follows a removed entry
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.AuditVerification
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/audit/verify [get]
+func (ac *AuditController) VerifyChain(c *gin.Context) {
+	result, err := ac.AuditService.VerifyChain()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,396 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/export"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+const maxImportSize = 10 << 20
+
+type CampaignController struct {
+	CampaignService services.CampaignService
+}
+
+func NewCampaignController(campaignService services.CampaignService) *CampaignController {
+	return &CampaignController{CampaignService: campaignService}
+}
+
+type CampaignRequest struct {
+	Name            string     `json:"name" binding:"required,max=100"`
+	Description     string     `json:"description" binding:"max=1000"`
+	StartsAt        *time.Time `json:"starts_at"`
+	EndsAt          *time.Time `json:"ends_at"`
+	ReferrerReward  int64      `json:"referrer_reward" binding:"min=0"`
+	RefereeReward   int64      `json:"referee_reward" binding:"min=0"`
+	MaxParticipants int        `json:"max_participants" binding:"min=0"`
+	TargetAudience  string     `json:"target_audience" binding:"omitempty,oneof=all verified_referrers"`
+	AllowedDomains  []string   `json:"allowed_domains" binding:"max=1000,dive,fqdn"`
+	BlockedDomains  []string   `json:"blocked_domains" binding:"max=1000,dive,fqdn"`
+}
+
+func (r CampaignRequest) input() services.CampaignInput {
+	return services.CampaignInput{
+		Name:            r.Name,
+		Description:     r.Description,
+		StartsAt:        r.StartsAt,
+		EndsAt:          r.EndsAt,
+		ReferrerReward:  r.ReferrerReward,
+		RefereeReward:   r.RefereeReward,
+		MaxParticipants: r.MaxParticipants,
+		TargetAudience:  r.TargetAudience,
+		AllowedDomains:  r.AllowedDomains,
+		BlockedDomains:  r.BlockedDomains,
+	}
+}
+
+type CampaignsResponse struct {
+	Campaigns []models.Campaign `json:"campaigns"`
+}
+
+type GenerateCodesRequest struct {
+	OwnerID   uint       `json:"owner_id" binding:"required"`
+	Count     int        `json:"count" binding:"required,min=1,max=100000"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type ImportCodesQuery struct {
+	DryRun     bool  `form:"dry_run"`
+	CampaignID *uint `form:"campaign_id"`
+}
+
+type CodeBatchResponse struct {
+	models.CodeBatch
+	Progress float64 `json:"progress" example:"0.5"`
+}
+
+func newCodeBatchResponse(batch *models.CodeBatch) CodeBatchResponse {
+	return CodeBatchResponse{
+		CodeBatch: *batch,
+		Progress:  float64(batch.Generated) / float64(batch.Requested),
+	}
+}
+
+// CreateCampaign godoc
+// @Summary Create campaign (admin)
+// @Description Create a referral campaign with its own date range, rewards and participant limit
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param campaign body CampaignRequest true "Campaign"
+// @Success 201 {object} models.Campaign
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/campaigns [post]
+func (cc *CampaignController) CreateCampaign(c *gin.Context) {
+	var req CampaignRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	campaign, err := cc.CampaignService.CreateCampaign(req.input())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCampaignDates) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+// GetCampaign godoc
+// @Summary Get campaign (admin)
+// @Description Get a referral campaign by ID
+// @Tags admin
+// @Produce json
+// @Param id path int true "Campaign ID"
+// @Success 200 {object} models.Campaign
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/campaigns/{id} [get]
+func (cc *CampaignController) GetCampaign(c *gin.Context) {
+	campaignID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	campaign, err := cc.CampaignService.GetCampaign(campaignID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// UpdateCampaign godoc
+// @Summary Update campaign (admin)
+// @Description Replace the settings of a referral campaign
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Campaign ID"
+// @Param campaign body CampaignRequest true "Campaign"
+// @Success 200 {object} models.Campaign
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/campaigns/{id} [put]
+func (cc *CampaignController) UpdateCampaign(c *gin.Context) {
+	campaignID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req CampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	campaign, err := cc.CampaignService.UpdateCampaign(campaignID, req.input())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// DeleteCampaign godoc
+// @Summary Delete campaign (admin)
+// @Description Delete a referral campaign. Codes belonging to it stop working.
+// @Tags admin
+// @Produce json
+// @Param id path int true "Campaign ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/campaigns/{id} [delete]
+func (cc *CampaignController) DeleteCampaign(c *gin.Context) {
+	campaignID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := cc.CampaignService.DeleteCampaign(campaignID); err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{Message: "Campaign deleted"})
+}
+
+// GetCampaignStats godoc
+// @Summary Get campaign analytics (admin)
+// @Description Referral, code and reward totals of a campaign, with referrals per day
+// @Tags admin
+// @Produce json
+// @Param id path int true "Campaign ID"
+// @Success 200 {object} models.CampaignStats
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/campaigns/{id}/stats [get]
+func (cc *CampaignController) GetCampaignStats(c *gin.Context) {
+	campaignID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	stats, err := cc.CampaignService.GetCampaignStats(campaignID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// ListCampaigns godoc
+// @Summary List campaigns (admin)
+// @Description List all referral campaigns, newest first
+// @Tags admin
+// @Produce json
+// @Success 200 {object} CampaignsResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/campaigns [get]
+func (cc *CampaignController) ListCampaigns(c *gin.Context) {
+	campaigns, err := cc.CampaignService.ListCampaigns()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, CampaignsResponse{Campaigns: campaigns})
+}
+
+// GenerateCodes godoc
+// @Summary Generate campaign codes (admin)
+// @Description Start a background job generating single-use codes for a campaign. Poll the returned batch for progress.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Campaign ID"
+// @Param batch body GenerateCodesRequest true "Code Batch"
+// @Success 202 {object} CodeBatchResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/campaigns/{id}/code_batches [post]
+func (cc *CampaignController) GenerateCodes(c *gin.Context) {
+	adminID := c.MustGet("userID").(uint)
+	campaignID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req GenerateCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	batch, err := cc.CampaignService.GenerateCodes(campaignID, req.OwnerID, req.Count, req.ExpiresAt, adminID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, newCodeBatchResponse(batch))
+}
+
+// GetCodeBatch godoc
+// @Summary Get code batch (admin)
+// @Description Get the status and progress of a code generation job
+// @Tags admin
+// @Produce json
+// @Param id path int true "Code Batch ID"
+// @Success 200 {object} CodeBatchResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/code_batches/{id} [get]
+func (cc *CampaignController) GetCodeBatch(c *gin.Context) {
+	batchID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	batch, err := cc.CampaignService.GetCodeBatch(batchID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newCodeBatchResponse(batch))
+}
+
+// DownloadCodeBatch godoc
+// @Summary Download code batch (admin)
+// @Description Download the codes of a completed batch as CSV
+// @Tags admin
+// @Produce text/csv
+// @Param id path int true "Code Batch ID"
+// @Success 200 {file} file
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/code_batches/{id}/download [get]
+func (cc *CampaignController) DownloadCodeBatch(c *gin.Context) {
+	batchID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	startExport(c, fmt.Sprintf("code-batch-%d", batchID), export.FormatCSV)
+	err := cc.CampaignService.ExportCodeBatch(batchID, c.Writer)
+	if errors.Is(err, services.ErrCodeBatchNotReady) {
+		c.Header("Content-Type", "")
+		c.Header("Content-Disposition", "")
+		c.JSON(http.StatusConflict, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	finishExport(c, err)
+}
+
+// ImportCodes godoc
+// @Summary Import promo codes from CSV (admin)
+// @Description Import externally generated codes. The CSV needs code and owner_email columns and may have expires_at and max_uses. Every row is validated; valid rows are stored in a single transaction unless dry_run is set.
+// @Tags admin
+// @Accept text/csv
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file false "CSV file (when sent as multipart/form-data)"
+// @Param dry_run query bool false "Only validate, do not store"
+// @Param campaign_id query int false "Campaign the codes belong to"
+// @Success 200 {object} models.CodeImportReport
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/promo_codes/import [post]
+func (cc *CampaignController) ImportCodes(c *gin.Context) {
+	var query ImportCodesQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	var body io.Reader = c.Request.Body
+	if c.ContentType() == "multipart/form-data" {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	report, err := cc.CampaignService.ImportCodes(body, query.CampaignID, query.DryRun)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || errors.Is(err, services.ErrInvalidImportFile) ||
+			errors.Is(err, services.ErrImportMissingColumns) || errors.Is(err, services.ErrImportTooManyRows) ||
+			errors.Is(err, services.ErrCampaignNotFound) || errors.Is(err, services.ErrCampaignEnded) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
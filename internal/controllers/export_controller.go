@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/export"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type ExportController struct {
+	ExportService services.ExportService
+}
+
+func NewExportController(exportService services.ExportService) *ExportController {
+	return &ExportController{ExportService: exportService}
+}
+
+type ExportQuery struct {
+	Format string `form:"format,default=csv" binding:"oneof=csv jsonl"`
+}
+
+type ReferralExportQuery struct {
+	ExportQuery
+	From   time.Time `form:"from"`
+	To     time.Time `form:"to"`
+	Status string    `form:"status" binding:"omitempty,oneof=pending qualified rejected"`
+	Code   string    `form:"code"`
+	Sort   string    `form:"sort,default=created_at_desc" binding:"oneof=created_at_desc created_at_asc"`
+}
+
+type AdminReferralExportQuery struct {
+	ReferralExportQuery
+	ReferrerID uint `form:"referrer_id"`
+	CampaignID uint `form:"campaign_id"`
+}
+
+func (q ReferralExportQuery) listOptions() services.ReferralListOptions {
+	return services.ReferralListOptions{
+		From:   q.From,
+		To:     q.To,
+		Status: q.Status,
+		Code:   q.Code,
+		Sort:   q.Sort,
+	}
+}
+
+// ExportReferrals godoc
+// @Summary Export own referrals
+// @Description Stream the authenticated user's referrals as CSV or JSON Lines. Accepts the same filters as GET /referrals.
+// @Tags export
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param format query string false "Export format" Enums(csv, jsonl)
+// @Param from query string false "Created at or after (RFC3339)"
+// @Param to query string false "Created before (RFC3339)"
+// @Param status query string false "Referral status" Enums(pending, qualified, rejected)
+// @Param code query string false "Referral code used"
+// @Param sort query string false "Sort order" Enums(created_at_desc, created_at_asc)
+// @Success 200 {file} file
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /referrals/export [get]
+func (ec *ExportController) ExportReferrals(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var query ReferralExportQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	startExport(c, "referrals", query.Format)
+	err := ec.ExportService.ExportUserReferrals(userID, query.listOptions(), query.Format, c.Writer)
+	finishExport(c, err)
+}
+
+// ExportAllReferrals godoc
+// @Summary Export referrals (admin)
+// @Description Stream referrals of all users, or of one referrer, as CSV or JSON Lines
+// @Tags admin
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param format query string false "Export format" Enums(csv, jsonl)
+// @Param referrer_id query int false "Referrer user ID"
+// @Param campaign_id query int false "Campaign ID"
+// @Param from query string false "Created at or after (RFC3339)"
+// @Param to query string false "Created before (RFC3339)"
+// @Param status query string false "Referral status" Enums(pending, qualified, rejected)
+// @Param code query string false "Referral code used"
+// @Param sort query string false "Sort order" Enums(created_at_desc, created_at_asc)
+// @Success 200 {file} file
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/export/referrals [get]
+func (ec *ExportController) ExportAllReferrals(c *gin.Context) {
+	var query AdminReferralExportQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	opts := query.listOptions()
+	opts.CampaignID = query.CampaignID
+
+	startExport(c, "referrals", query.Format)
+	err := ec.ExportService.ExportReferrals(query.ReferrerID, opts, query.Format, c.Writer)
+	finishExport(c, err)
+}
+
+// ExportUsers godoc
+// @Summary Export users (admin)
+// @Description Stream all users as CSV or JSON Lines
+// @Tags admin
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param format query string false "Export format" Enums(csv, jsonl)
+// @Success 200 {file} file
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/export/users [get]
+func (ec *ExportController) ExportUsers(c *gin.Context) {
+	var query ExportQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	startExport(c, "users", query.Format)
+	err := ec.ExportService.ExportUsers(query.Format, c.Writer)
+	finishExport(c, err)
+}
+
+// ExportRewards godoc
+// @Summary Export rewards (admin)
+// @Description Stream the reward ledger as CSV or JSON Lines
+// @Tags admin
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param format query string false "Export format" Enums(csv, jsonl)
+// @Success 200 {file} file
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/export/rewards [get]
+func (ec *ExportController) ExportRewards(c *gin.Context) {
+	var query ExportQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	startExport(c, "rewards", query.Format)
+	err := ec.ExportService.ExportRewards(query.Format, c.Writer)
+	finishExport(c, err)
+}
+
+func startExport(c *gin.Context, name, format string) {
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102"), format)
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+}
+
+// finishExport reports err as JSON if nothing was streamed yet. Once rows have
+// been sent the status line is gone, so the error is only recorded.
+func finishExport(c *gin.Context, err error) {
+	if err == nil {
+		return
+	}
+
+	if c.Writer.Written() {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("Content-Type", "")
+	c.Header("Content-Disposition", "")
+	if errors.Is(err, services.ErrInvalidReferralSort) || errors.Is(err, export.ErrUnsupportedFormat) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type LeaderboardController struct {
+	LeaderboardService services.LeaderboardService
+}
+
+func NewLeaderboardController(leaderboardService services.LeaderboardService) *LeaderboardController {
+	return &LeaderboardController{LeaderboardService: leaderboardService}
+}
+
+type LeaderboardQuery struct {
+	Period   string `form:"period,default=all_time"`
+	Page     int    `form:"page,default=1" binding:"min=1"`
+	PageSize int    `form:"page_size,default=10" binding:"min=1,max=100"`
+}
+
+type LeaderboardPreferencesRequest struct {
+	DisplayName string `json:"display_name" binding:"max=32"`
+	OptOut      bool   `json:"opt_out"`
+}
+
+type LeaderboardPreferencesResponse struct {
+	DisplayName string `json:"display_name"`
+	OptOut      bool   `json:"opt_out"`
+}
+
+// GetLeaderboard godoc
+// @Summary Get referrer leaderboard
+// @Description Rank users by qualified referrals for the given period. Users who opted out are not listed.
+// @Tags leaderboard
+// @Produce json
+// @Param period query string false "Period" Enums(all_time, month, week)
+// @Param page query int false "Page number" minimum(1)
+// @Param page_size query int false "Page size" minimum(1) maximum(100)
+// @Success 200 {object} models.Leaderboard
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /leaderboard [get]
+func (lc *LeaderboardController) GetLeaderboard(c *gin.Context) {
+	var query LeaderboardQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	leaderboard, err := lc.LeaderboardService.GetLeaderboard(query.Period, query.Page, query.PageSize)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidLeaderboardPeriod) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, leaderboard)
+}
+
+// UpdatePreferences godoc
+// @Summary Update leaderboard preferences
+// @Description Set the public display name and opt in or out of the leaderboard
+// @Tags leaderboard
+// @Accept json
+// @Produce json
+// @Param preferences body LeaderboardPreferencesRequest true "Leaderboard Preferences"
+// @Success 200 {object} LeaderboardPreferencesResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /leaderboard/preferences [put]
+func (lc *LeaderboardController) UpdatePreferences(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req LeaderboardPreferencesRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user, err := lc.LeaderboardService.UpdatePreferences(userID, req.DisplayName, req.OptOut)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, LeaderboardPreferencesResponse{
+		DisplayName: user.DisplayName,
+		OptOut:      user.LeaderboardOptOut,
+	})
+}
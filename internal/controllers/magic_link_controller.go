@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type MagicLinkController struct {
+	MagicLinkService services.MagicLinkService
+}
+
+func NewMagicLinkController(magicLinkService services.MagicLinkService) *MagicLinkController {
+	return &MagicLinkController{MagicLinkService: magicLinkService}
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// SendLoginLink godoc
+// @Summary Request a login link
+// @Description Email a single-use login link to the address. The response is the same whether or not the address has an account.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body MagicLinkRequest true "Email"
+// @Success 202 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Router /login/magic_link [post]
+func (mc *MagicLinkController) SendLoginLink(c *gin.Context) {
+	var req MagicLinkRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := mc.MagicLinkService.SendLoginLink(req.Email, requestMeta(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.SuccessResponse{Message: "If the address belongs to an account, a login link is on its way"})
+}
+
+// Verify godoc
+// @Summary Log in with a login link
+// @Description Redeem a login link and return a JWT token. Each link works once and confirms the email address.
+// @Tags auth
+// @Produce json
+// @Param token query string true "Token from the login link"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /login/magic_link/verify [get]
+func (mc *MagicLinkController) Verify(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "token is required"})
+		return
+	}
+
+	jwt, err := mc.MagicLinkService.Verify(token, requestMeta(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidMagicLink):
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
+		case errors.Is(err, services.ErrAccountInactive):
+			c.JSON(http.StatusForbidden, models.ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, TokenResponse{Token: jwt})
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/captcha"
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/oidc"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type OIDCController struct {
+	OIDCService services.OIDCService
+	Captcha     *captcha.Policy
+}
+
+func NewOIDCController(oidcService services.OIDCService, captchaPolicy *captcha.Policy) *OIDCController {
+	return &OIDCController{OIDCService: oidcService, Captcha: captchaPolicy}
+}
+
+type ProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+type OIDCLoginResponse struct {
+	Token   string       `json:"token"`
+	Created bool         `json:"created"`
+	User    *models.User `json:"user"`
+}
+
+// ListProviders godoc
+// @Summary List identity providers
+// @Description List the configured providers that can be used to sign in
+// @Tags auth
+// @Produce json
+// @Success 200 {object} ProvidersResponse
+// @Router /auth/oidc/providers [get]
+func (oc *OIDCController) ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, ProvidersResponse{Providers: oc.OIDCService.Providers()})
+}
+
+// StartLogin godoc
+// @Summary Sign in with an identity provider
+// @Description Redirect to the provider's login page. A referral code and accepted terms version passed here are applied if the login creates a new account. Both are checked before redirecting. With a referral code a solved CAPTCHA may be required as captcha_token, as for /register_with_referral.
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Param referral_code query string false "Referral code for new accounts"
+// @Param terms_version query string false "Version of the programme terms the user accepted, required to create an account once terms are published"
+// @Param captcha_token query string false "Token of a solved CAPTCHA"
+// @Success 302
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /auth/oidc/{provider}/login [get]
+func (oc *OIDCController) StartLogin(c *gin.Context) {
+	// Referral signups are rewarded, so they are protected like
+	// /register_with_referral; plain logins and signups are not.
+	if c.Query("referral_code") != "" && !checkCaptcha(c, oc.Captcha, c.Query("captcha_token"), requestMeta(c)) {
+		return
+	}
+
+	authURL, err := oc.OIDCService.StartLogin(c.Param("provider"), c.Query("referral_code"), c.Query("terms_version"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownProvider):
+			c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+			return
+		case errors.Is(err, services.ErrInvalidReferralCode), errors.Is(err, services.ErrCampaignNotStarted),
+			errors.Is(err, services.ErrCampaignEnded), errors.Is(err, services.ErrCampaignFull),
+			errors.Is(err, services.ErrNotInCampaignAudience), errors.Is(err, services.ErrTermsOutdated):
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback godoc
+// @Summary Complete sign in with an identity provider
+// @Description Called by the provider after the user signed in. Logs into the linked account, links an account with the same verified email, or creates a new one attributed to the referral code given at the start.
+// @Tags auth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param state query string true "State"
+// @Param code query string true "Authorization code"
+// @Success 200 {object} OIDCLoginResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Router /auth/oidc/{provider}/callback [get]
+func (oc *OIDCController) Callback(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "login was not completed: " + providerError})
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "state and code are required"})
+		return
+	}
+
+	result, err := oc.OIDCService.CompleteLogin(c.Param("provider"), state, code, requestMeta(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownProvider):
+			c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		case errors.Is(err, oidc.ErrInvalidIDToken):
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
+		case errors.Is(err, services.ErrAccountInactive):
+			c.JSON(http.StatusForbidden, models.ErrorResponse{Error: err.Error()})
+		case errors.Is(err, services.ErrIdentityEmailTaken):
+			c.JSON(http.StatusConflict, models.ErrorResponse{Error: err.Error()})
+		case errors.Is(err, services.ErrInvalidOAuthState), errors.Is(err, services.ErrIdentityEmailMissing),
+			errors.Is(err, services.ErrInvalidReferralCode), errors.Is(err, services.ErrCampaignNotStarted),
+			errors.Is(err, services.ErrCampaignEnded), errors.Is(err, services.ErrCampaignFull),
+			errors.Is(err, services.ErrNotInCampaignAudience), errors.Is(err, services.ErrTermsOutdated),
+			errors.Is(err, services.ErrTermsRequired), errors.Is(err, services.ErrEmailDomainNotAllowed),
+			errors.Is(err, emailpolicy.ErrDisposableEmail):
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusBadGateway, models.ErrorResponse{Error: err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, OIDCLoginResponse{Token: result.Token, Created: result.Created, User: result.User})
+}
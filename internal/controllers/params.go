@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/captcha"
+	"github.com/serlenario/referral-system/internal/models"
+)
+
+// idParam parses a numeric path parameter, responding with 400 if it is invalid.
+func idParam(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid " + name})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// checkCaptcha applies the CAPTCHA policy to a signup, responding with 403,
+// or 503 if the token could not be verified, when it may not go ahead.
+func checkCaptcha(c *gin.Context, policy *captcha.Policy, token string, meta models.RequestMeta) bool {
+	err := policy.Check(token, captcha.Signals{IP: meta.IP, UserAgent: meta.UserAgent})
+	if err == nil {
+		return true
+	}
+
+	status := http.StatusForbidden
+	if errors.Is(err, captcha.ErrCaptchaUnavailable) {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, models.ErrorResponse{Error: err.Error()})
+	return false
+}
+
+// requestMeta collects the caller details recorded in the audit log.
+func requestMeta(c *gin.Context) models.RequestMeta {
+	meta := models.RequestMeta{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if userID, ok := c.Get("userID"); ok {
+		id := userID.(uint)
+		meta.ActorID = &id
+	}
+	return meta
+}
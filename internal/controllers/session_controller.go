@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type SessionController struct {
+	SessionService services.SessionService
+}
+
+func NewSessionController(sessionService services.SessionService) *SessionController {
+	return &SessionController{SessionService: sessionService}
+}
+
+// ListSessions godoc
+// @Summary List sessions
+// @Description List the devices the authenticated user is logged in on. The session of the current token is marked as current.
+// @Tags sessions
+// @Produce json
+// @Success 200 {array} models.Session
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /sessions [get]
+func (sc *SessionController) ListSessions(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	sessions, err := sc.SessionService.List(userID, c.GetUint("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary End session
+// @Description Log out one of the authenticated user's devices. Tokens of that session stop working immediately; ending the current session logs out this client.
+// @Tags sessions
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /sessions/{id} [delete]
+func (sc *SessionController) RevokeSession(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	sessionID, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := sc.SessionService.Revoke(userID, sessionID, requestMeta(c)); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{Message: "Session ended"})
+}
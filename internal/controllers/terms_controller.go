@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/services"
+)
+
+type TermsController struct {
+	TermsService services.TermsService
+}
+
+func NewTermsController(termsService services.TermsService) *TermsController {
+	return &TermsController{TermsService: termsService}
+}
+
+type PublishTermsRequest struct {
+	Version string `json:"version" binding:"required,max=50"`
+	URL     string `json:"url" binding:"required,url"`
+	Summary string `json:"summary" binding:"max=1000"`
+}
+
+type AcceptTermsRequest struct {
+	Version string `json:"version" binding:"required"`
+}
+
+// GetCurrentTerms godoc
+// @Summary Get current terms
+// @Description Get the current version of the referral programme terms. Clients pass its version when signing up or accepting updated terms.
+// @Tags terms
+// @Produce json
+// @Success 200 {object} models.TermsVersion
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /terms [get]
+func (tc *TermsController) GetCurrentTerms(c *gin.Context) {
+	terms, err := tc.TermsService.Current()
+	if err != nil {
+		termsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, terms)
+}
+
+// GetTermsStatus godoc
+// @Summary Get own terms status
+// @Description Get the current terms and the latest version the authenticated user accepted
+// @Tags terms
+// @Produce json
+// @Success 200 {object} services.TermsStatus
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /me/terms [get]
+func (tc *TermsController) GetTermsStatus(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	status, err := tc.TermsService.Status(userID)
+	if err != nil {
+		termsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// AcceptTerms godoc
+// @Summary Accept terms
+// @Description Accept the current version of the referral programme terms. Rewards held back until then are granted.
+// @Tags terms
+// @Accept json
+// @Produce json
+// @Param terms body AcceptTermsRequest true "Accepted version"
+// @Success 200 {object} services.TermsStatus
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /me/terms [post]
+func (tc *TermsController) AcceptTerms(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req AcceptTermsRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	status, err := tc.TermsService.Accept(userID, req.Version, requestMeta(c))
+	if err != nil {
+		termsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// ListTerms godoc
+// @Summary List terms versions (admin)
+// @Description List all published versions of the referral programme terms, newest first
+// @Tags admin
+// @Produce json
+// @Success 200 {array} models.TermsVersion
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/terms [get]
+func (tc *TermsController) ListTerms(c *gin.Context) {
+	versions, err := tc.TermsService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// PublishTerms godoc
+// @Summary Publish terms version (admin)
+// @Description Publish a new version of the referral programme terms. It becomes current immediately, and users get no further rewards until they accept it.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param terms body PublishTermsRequest true "Terms"
+// @Success 201 {object} models.TermsVersion
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/terms [post]
+func (tc *TermsController) PublishTerms(c *gin.Context) {
+	var req PublishTermsRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	terms, err := tc.TermsService.Publish(services.TermsInput{
+		Version: req.Version,
+		URL:     req.URL,
+		Summary: req.Summary,
+	}, requestMeta(c))
+	if err != nil {
+		termsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, terms)
+}
+
+func termsError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrNoTerms):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrTermsRequired):
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrTermsOutdated), errors.Is(err, services.ErrTermsVersionTaken):
+		c.JSON(http.StatusConflict, models.ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+	}
+}
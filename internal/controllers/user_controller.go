@@ -1,25 +1,34 @@
 package controllers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/captcha"
 	"github.com/serlenario/referral-system/internal/models"
 	"github.com/serlenario/referral-system/internal/services"
 )
 
 type UserController struct {
-	UserService services.UserService
+	UserService      services.UserService
+	MagicLinkService services.MagicLinkService
+	Captcha          *captcha.Policy
 }
 
-func NewUserController(userService services.UserService) *UserController {
-	return &UserController{UserService: userService}
+func NewUserController(userService services.UserService, magicLinkService services.MagicLinkService, captchaPolicy *captcha.Policy) *UserController {
+	return &UserController{UserService: userService, MagicLinkService: magicLinkService, Captcha: captchaPolicy}
 }
 
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
+	// TermsVersion is the version of the programme terms the user accepted.
+	// It is required once terms are published.
+	TermsVersion string `json:"terms_version"`
 }
 
 type LoginRequest struct {
@@ -32,7 +41,8 @@ type TokenResponse struct {
 }
 
 type CreateReferralRequest struct {
-	Expiry time.Time `json:"expiry" binding:"required"`
+	Expiry     time.Time `json:"expiry" binding:"required"`
+	CampaignID *uint     `json:"campaign_id"`
 }
 
 type ReferralResponse struct {
@@ -40,25 +50,57 @@ type ReferralResponse struct {
 	Expiry       time.Time `json:"expiry"`
 }
 
+type OwnReferralCodeResponse struct {
+	ReferralCode string    `json:"referral_code"`
+	Expiry       time.Time `json:"expiry"`
+	CampaignID   *uint     `json:"campaign_id,omitempty"`
+	Public       bool      `json:"public"`
+}
+
+type ReferralCodeVisibilityRequest struct {
+	Public *bool `json:"public" binding:"required"`
+}
+
 type RegisterWithReferralRequest struct {
 	ReferralCode string `json:"referral_code" binding:"required"`
 	Email        string `json:"email" binding:"required,email"`
-	Password     string `json:"password" binding:"required,min=6"`
+	// Password may be left out to sign up without one; a login link is then
+	// emailed instead.
+	Password string `json:"password"`
+	// TermsVersion is the version of the programme terms the user accepted.
+	// It is required once terms are published.
+	TermsVersion string `json:"terms_version"`
+	// CaptchaToken is the token of a solved CAPTCHA, needed when the
+	// response to an attempt without it was "captcha required".
+	CaptchaToken string `json:"captcha_token"`
+}
+
+type ReferralsQuery struct {
+	From   time.Time `form:"from"`
+	To     time.Time `form:"to"`
+	Status string    `form:"status" binding:"omitempty,oneof=pending qualified rejected"`
+	Code   string    `form:"code"`
+	Sort   string    `form:"sort,default=created_at_desc" binding:"oneof=created_at_desc created_at_asc"`
+	Cursor string    `form:"cursor"`
+	Limit  int       `form:"limit,default=50" binding:"min=1,max=100"`
 }
 
 type ReferralsResponse struct {
-	Referrals []models.Referral `json:"referrals"`
+	Referrals  []models.ReferralDetails `json:"referrals"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	Total      int64                    `json:"total"`
 }
 
 // Register godoc
 // @Summary Register a new user
-// @Description Register a new user with email and password
+// @Description Register a new user with email and password. Once programme terms are published, terms_version is required and has to be the current version.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param user body RegisterRequest true "Register User"
 // @Success 201 {object} models.User
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Router /register [post]
 func (uc *UserController) Register(c *gin.Context) {
 	var req RegisterRequest
@@ -68,7 +110,7 @@ func (uc *UserController) Register(c *gin.Context) {
 		return
 	}
 
-	user, err := uc.UserService.Register(req.Email, req.Password)
+	user, err := uc.UserService.Register(req.Email, req.Password, req.TermsVersion, requestMeta(c))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
@@ -86,6 +128,8 @@ func (uc *UserController) Register(c *gin.Context) {
 // @Param credentials body LoginRequest true "Login Credentials"
 // @Success 200 {object} TokenResponse
 // @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
 // @Router /login [post]
 func (uc *UserController) Login(c *gin.Context) {
 	var req LoginRequest
@@ -95,8 +139,14 @@ func (uc *UserController) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := uc.UserService.Authenticate(req.Email, req.Password)
+	token, err := uc.UserService.Authenticate(req.Email, req.Password, requestMeta(c))
 	if err != nil {
+		var throttled *services.LoginThrottledError
+		if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, models.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -106,7 +156,7 @@ func (uc *UserController) Login(c *gin.Context) {
 
 // CreateReferralCode godoc
 // @Summary Create referral code
-// @Description Create a new referral code with expiry date
+// @Description Create a new referral code with expiry date, optionally taking part in a campaign
 // @Tags referral
 // @Accept json
 // @Produce json
@@ -125,8 +175,12 @@ func (uc *UserController) CreateReferralCode(c *gin.Context) {
 		return
 	}
 
-	user, err := uc.UserService.CreateReferralCode(userID, req.Expiry)
+	user, err := uc.UserService.CreateReferralCode(userID, req.Expiry, req.CampaignID, requestMeta(c))
 	if err != nil {
+		if req.CampaignID != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -149,7 +203,7 @@ func (uc *UserController) CreateReferralCode(c *gin.Context) {
 func (uc *UserController) DeleteReferralCode(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	user, err := uc.UserService.DeleteReferralCode(userID)
+	user, err := uc.UserService.DeleteReferralCode(userID, requestMeta(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
 		return
@@ -163,13 +217,14 @@ func (uc *UserController) DeleteReferralCode(c *gin.Context) {
 
 // GetReferralCodeByEmail godoc
 // @Summary Get referral code by email
-// @Description Retrieve a user's referral code using their email
+// @Description Retrieve the referral code of a user who made it public. Unknown emails and private, missing or expired codes all get the same 404 response.
 // @Tags referral
 // @Produce json
 // @Param email query string true "User Email"
 // @Success 200 {object} ReferralResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Router /referral_code [get]
 func (uc *UserController) GetReferralCodeByEmail(c *gin.Context) {
 	email := c.Query("email")
@@ -180,22 +235,81 @@ func (uc *UserController) GetReferralCodeByEmail(c *gin.Context) {
 
 	code, err := uc.UserService.GetReferralCodeByEmail(email)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: services.ErrNoReferralCode.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, ReferralResponse{ReferralCode: code})
 }
 
+// GetOwnReferralCode godoc
+// @Summary Get own referral code
+// @Description Retrieve the authenticated user's referral code, whether or not it is public
+// @Tags referral
+// @Produce json
+// @Success 200 {object} OwnReferralCodeResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /referral_code/me [get]
+func (uc *UserController) GetOwnReferralCode(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	user, err := uc.UserService.GetOwnReferralCode(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, OwnReferralCodeResponse{
+		ReferralCode: user.ReferralCode,
+		Expiry:       user.ReferralExpiry,
+		CampaignID:   user.ReferralCampaignID,
+		Public:       user.ReferralCodePublic,
+	})
+}
+
+// SetReferralCodeVisibility godoc
+// @Summary Set referral code visibility
+// @Description Choose whether others can look up your referral code by your email. Codes are private by default.
+// @Tags referral
+// @Accept json
+// @Produce json
+// @Param visibility body ReferralCodeVisibilityRequest true "Visibility"
+// @Success 200 {object} models.User
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /referral_code/visibility [put]
+func (uc *UserController) SetReferralCodeVisibility(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req ReferralCodeVisibilityRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user, err := uc.UserService.SetReferralCodeVisibility(userID, *req.Public, requestMeta(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
 // RegisterWithReferral godoc
 // @Summary Register with referral code
-// @Description Register a new user using a referral code
+// @Description Register a new user using a personal or promo referral code. Codes of campaigns that have ended or are full are rejected. Without a password the account is passwordless and a login link is emailed. Once programme terms are published, terms_version is required and has to be the current version. A solved CAPTCHA may be required as captcha_token; the response is then 403 with the error "captcha required".
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param user body RegisterWithReferralRequest true "Register with Referral"
 // @Success 201 {object} models.User
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /register_with_referral [post]
 func (uc *UserController) RegisterWithReferral(c *gin.Context) {
 	var req RegisterWithReferralRequest
@@ -205,7 +319,18 @@ func (uc *UserController) RegisterWithReferral(c *gin.Context) {
 		return
 	}
 
-	user, err := uc.UserService.RegisterWithReferral(req.ReferralCode, req.Email, req.Password)
+	meta := requestMeta(c)
+	if !checkCaptcha(c, uc.Captcha, req.CaptchaToken, meta) {
+		return
+	}
+
+	var user *models.User
+	var err error
+	if req.Password == "" {
+		user, err = uc.MagicLinkService.RegisterWithReferral(req.ReferralCode, req.Email, req.TermsVersion, meta)
+	} else {
+		user, err = uc.UserService.RegisterWithReferral(req.ReferralCode, req.Email, req.Password, req.TermsVersion, meta)
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
@@ -216,21 +341,56 @@ func (uc *UserController) RegisterWithReferral(c *gin.Context) {
 
 // GetReferrals godoc
 // @Summary Get user referrals
-// @Description Retrieve a list of users referred by the authenticated user
+// @Description Retrieve a page of users referred by the authenticated user, with masked details of each referred user. Pass next_cursor from the previous response to fetch the following page.
 // @Tags referral
 // @Produce json
+// @Param from query string false "Created at or after (RFC3339)"
+// @Param to query string false "Created before (RFC3339)"
+// @Param status query string false "Referral status" Enums(pending, qualified, rejected)
+// @Param code query string false "Referral code used"
+// @Param sort query string false "Sort order" Enums(created_at_desc, created_at_asc)
+// @Param cursor query string false "Pagination cursor"
+// @Param limit query int false "Page size" minimum(1) maximum(100)
 // @Success 200 {object} ReferralsResponse
+// @Failure 400 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /referrals [get]
 func (uc *UserController) GetReferrals(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	referrals, err := uc.UserService.GetReferrals(userID)
+	listReferrals(c, uc.UserService, userID)
+}
+
+func listReferrals(c *gin.Context, userService services.UserService, userID uint) {
+	var query ReferralsQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	page, err := userService.GetReferrals(userID, services.ReferralListOptions{
+		From:   query.From,
+		To:     query.To,
+		Status: query.Status,
+		Code:   query.Code,
+		Sort:   query.Sort,
+		Cursor: query.Cursor,
+		Limit:  query.Limit,
+	})
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) || errors.Is(err, services.ErrInvalidReferralSort) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, ReferralsResponse{Referrals: referrals})
+	c.JSON(http.StatusOK, ReferralsResponse{
+		Referrals:  page.Referrals,
+		NextCursor: page.NextCursor,
+		Total:      page.Total,
+	})
 }
@@ -0,0 +1,137 @@
+package emailpolicy
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed disposable.txt
+var builtinDisposable string
+
+// DomainList is the built-in list of disposable domains extended by an
+// optional file. The file is checked for changes at most once per reload
+// interval and read again when it has been modified, so the list can be
+// updated without a restart.
+type DomainList struct {
+	path           string
+	reloadInterval time.Duration
+
+	mu          sync.RWMutex
+	domains     map[string]struct{}
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+// NewDomainList loads the built-in list and the file at path, if given.
+func NewDomainList(path string, reloadInterval time.Duration) (*DomainList, error) {
+	l := &DomainList{path: path, reloadInterval: reloadInterval}
+
+	domains, modTime, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	l.domains = domains
+	l.modTime = modTime
+	l.lastChecked = time.Now()
+	return l, nil
+}
+
+// Contains reports whether domain or one of its parent domains is listed.
+func (l *DomainList) Contains(domain string) bool {
+	l.maybeReload(time.Now())
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for {
+		if _, ok := l.domains[domain]; ok {
+			return true
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// maybeReload reads the file again if it changed since it was last loaded. A
+// file that cannot be read leaves the current list in place.
+func (l *DomainList) maybeReload(now time.Time) {
+	if l.path == "" {
+		return
+	}
+
+	l.mu.Lock()
+	if now.Sub(l.lastChecked) < l.reloadInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.lastChecked = now
+	modTime := l.modTime
+	l.mu.Unlock()
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		log.Printf("disposable domains: %v", err)
+		return
+	}
+	if info.ModTime().Equal(modTime) {
+		return
+	}
+
+	domains, modTime, err := l.load()
+	if err != nil {
+		log.Printf("disposable domains: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	l.domains = domains
+	l.modTime = modTime
+	l.mu.Unlock()
+	log.Printf("disposable domains: reloaded %s, %d domains", l.path, len(domains))
+}
+
+func (l *DomainList) load() (map[string]struct{}, time.Time, error) {
+	domains := make(map[string]struct{})
+	if err := addDomains(domains, strings.NewReader(builtinDisposable)); err != nil {
+		return nil, time.Time{}, err
+	}
+	if l.path == "" {
+		return domains, time.Time{}, nil
+	}
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if err := addDomains(domains, f); err != nil {
+		return nil, time.Time{}, err
+	}
+	return domains, info.ModTime(), nil
+}
+
+func addDomains(domains map[string]struct{}, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[line] = struct{}{}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,89 @@
+// Package emailpolicy decides which email addresses may sign up. It
+// normalises addresses so that aliases of one mailbox are recognised as the
+// same address, and detects disposable domains.
+package emailpolicy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Actions for addresses at disposable domains.
+const (
+	ActionReject = "reject"
+	ActionFlag   = "flag"
+	ActionOff    = "off"
+)
+
+// FlagDisposable marks users who signed up with a disposable address.
+const FlagDisposable = "disposable_domain"
+
+var ErrDisposableEmail = errors.New("disposable email addresses are not accepted")
+
+// Policy checks new addresses against the list of disposable domains.
+type Policy struct {
+	disposable *DomainList
+	action     string
+}
+
+func NewPolicy(disposable *DomainList, action string) (*Policy, error) {
+	switch action {
+	case ActionReject, ActionFlag, ActionOff:
+	default:
+		return nil, fmt.Errorf("unknown disposable email action %q", action)
+	}
+	return &Policy{disposable: disposable, action: action}, nil
+}
+
+// Check returns the flag to record for a new address, if any, or
+// ErrDisposableEmail if the address must be rejected.
+func (p *Policy) Check(email string) (string, error) {
+	if p.action == ActionOff || !p.disposable.Contains(Domain(email)) {
+		return "", nil
+	}
+	if p.action == ActionFlag {
+		return FlagDisposable, nil
+	}
+	return "", ErrDisposableEmail
+}
+
+// Normalize returns the canonical form of an address, so that aliases of
+// the same mailbox compare equal: it is lowercased, a +tag in the local part
+// is dropped, and for Gmail dots in the local part are ignored too.
+func Normalize(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+
+	if plus := strings.IndexByte(local, '+'); plus > 0 {
+		local = local[:plus]
+	}
+	if domain == "googlemail.com" {
+		domain = "gmail.com"
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
+
+// Domain returns the lowercased domain of an address.
+func Domain(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	return email[strings.LastIndexByte(email, '@')+1:]
+}
+
+// MatchDomain reports whether domain is one of domains or a subdomain of one.
+func MatchDomain(domain string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" && (domain == d || strings.HasSuffix(domain, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
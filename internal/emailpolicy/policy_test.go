@@ -0,0 +1,29 @@
+package emailpolicy
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{"unchanged", "user@example.com", "user@example.com"},
+		{"case and spaces", "  User@Example.COM ", "user@example.com"},
+		{"tag", "user+news@example.com", "user@example.com"},
+		{"tags after the first plus", "user+a+b@example.com", "user@example.com"},
+		{"dots kept elsewhere", "first.last@example.com", "first.last@example.com"},
+		{"gmail dots", "First.Last@gmail.com", "firstlast@gmail.com"},
+		{"gmail dots and tag", "f.irst.last+x@gmail.com", "firstlast@gmail.com"},
+		{"googlemail", "first.last@googlemail.com", "firstlast@gmail.com"},
+		{"leading plus", "+tag@example.com", "+tag@example.com"},
+		{"no at sign", " User.Name+x ", "user.name+x"},
+		{"last at sign", "a@b@Example.com", "a@b@example.com"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.email); got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", tt.name, tt.email, got, tt.want)
+		}
+	}
+}
@@ -3,33 +3,96 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"github.com/serlenario/referral-system/internal/services"
 	"github.com/serlenario/referral-system/internal/utils"
 )
 
-func JWTMiddleware(secret string) gin.HandlerFunc {
+// AuthMiddleware accepts either a JWT or an API key in the Authorization
+// header. It loads the user on every call, so suspending an account or
+// changing its role applies to tokens and keys that were already issued, and
+// rejects tokens whose session has been ended. Requests made with an API key
+// also carry the key's ID and scopes, which RequirePermission and
+// RequireScope check.
+func AuthMiddleware(secret string, userRepo repositories.UserRepository, sessionService services.SessionService, apiKeyService services.APIKeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		tokenStr, ok := bearerToken(c)
+		if !ok {
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
+		if !services.IsAPIKey(tokenStr) {
+			authenticateJWT(c, tokenStr, secret, userRepo, sessionService)
 			return
 		}
 
-		tokenStr := parts[1]
-		claims, err := utils.ParseJWT(tokenStr, secret)
+		key, user, err := apiKeyService.Authenticate(tokenStr)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
 			return
 		}
 
-		c.Set("userID", claims.UserID)
+		if !setUser(c, user) {
+			return
+		}
+		c.Set("apiKeyID", key.ID)
+		c.Set("scopes", key.Scopes)
 		c.Next()
 	}
 }
+
+func authenticateJWT(c *gin.Context, tokenStr, secret string, userRepo repositories.UserRepository, sessionService services.SessionService) {
+	claims, err := utils.ParseJWT(tokenStr, secret)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	if err := sessionService.Validate(claims.SessionID, claims.UserID); err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session has ended"})
+		return
+	}
+
+	user, err := userRepo.GetByID(claims.UserID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	if !setUser(c, user) {
+		return
+	}
+	c.Set("sessionID", claims.SessionID)
+	c.Next()
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		return "", false
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+func setUser(c *gin.Context, user *models.User) bool {
+	if !user.IsActive(time.Now()) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Account is " + user.Status})
+		return false
+	}
+
+	c.Set("userID", user.ID)
+	c.Set("role", user.Role)
+	return true
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/models"
+)
+
+// RequirePermission must run after AuthMiddleware and only lets requests
+// through whose role grants the given permission. Requests made with an API
+// key additionally need the permission among the key's scopes.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		if !models.HasPermission(role, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+
+		if !hasScope(c, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + permission + " scope"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScope limits what an API key can do on endpoints that any
+// authenticated user may call. Requests made with a JWT always pass.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasScope(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RejectAPIKeys keeps API keys away from endpoints only the user should
+// reach, such as managing the keys themselves.
+func RejectAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("apiKeyID"); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func hasScope(c *gin.Context, scope string) bool {
+	scopes, ok := c.Get("scopes")
+	if !ok {
+		return true
+	}
+	return slices.Contains(scopes.([]string), scope)
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// AccountExport is everything stored about a user, handed out on request.
+type AccountExport struct {
+	ExportedAt     time.Time          `json:"exported_at"`
+	Profile        *User              `json:"profile"`
+	Identities     []UserIdentity     `json:"identities"`
+	SignupReferral *Referral          `json:"signup_referral,omitempty"`
+	Referrals      []Referral         `json:"referrals"`
+	Rewards        []Reward           `json:"rewards"`
+	PromoCodes     []PromoCode        `json:"promo_codes"`
+	StatusHistory  []UserStatusChange `json:"status_history"`
+	Sessions       []Session          `json:"sessions"`
+	APIKeys        []APIKey           `json:"api_keys"`
+	AuditEntries   []AuditEntry       `json:"audit_entries"`
+	Terms          []TermsAcceptance  `json:"terms_acceptances"`
+}
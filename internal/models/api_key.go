@@ -0,0 +1,48 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Scopes for API keys. Besides these, a key can carry any permission its
+// owner's role grants, which lets it call the matching /admin endpoints.
+const (
+	ScopeReferralCodesRead  = "referral_codes:read"
+	ScopeReferralCodesWrite = "referral_codes:write"
+	ScopeReferralsList      = "referrals:list"
+	ScopeLeaderboardWrite   = "leaderboard:write"
+)
+
+var userScopes = []string{
+	ScopeReferralCodesRead,
+	ScopeReferralCodesWrite,
+	ScopeReferralsList,
+	ScopeLeaderboardWrite,
+}
+
+// ValidScope reports whether a user with the given role may put scope on a key.
+func ValidScope(role, scope string) bool {
+	return slices.Contains(userScopes, scope) || HasPermission(role, scope)
+}
+
+// APIKey lets another service call the API on behalf of its owner. Only a
+// hash of the key is stored; the prefix is kept in clear to look it up and
+// to tell keys apart.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"uniqueIndex;not null" json:"prefix"`
+	Hash       string     `gorm:"not null" json:"-"`
+	Scopes     []string   `gorm:"serializer:json;type:text" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Usable reports whether the key is neither revoked nor expired.
+func (k *APIKey) Usable(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AuditActionRegister           = "user.register"
+	AuditActionRoleChange         = "user.role_change"
+	AuditActionStatusChange       = "user.status_change"
+	AuditActionProfileUpdate      = "user.profile_update"
+	AuditActionPasswordChange     = "user.password_change"
+	AuditActionEmailChangeRequest = "user.email_change_request"
+	AuditActionEmailChange        = "user.email_change"
+	AuditActionDataExport         = "user.data_export"
+	AuditActionDeletionRequest    = "user.deletion_request"
+	AuditActionDeletionCancel     = "user.deletion_cancel"
+	AuditActionDeletionComplete   = "user.deletion_complete"
+	AuditActionLoginSucceeded     = "auth.login_succeeded"
+	AuditActionLoginFailed        = "auth.login_failed"
+	AuditActionLoginLocked        = "auth.login_locked"
+	AuditActionAccountUnlocked    = "auth.account_unlocked"
+	AuditActionIdentityLinked     = "auth.identity_linked"
+	AuditActionMagicLinkSent      = "auth.magic_link_sent"
+	AuditActionSessionRevoked     = "auth.session_revoked"
+	AuditActionReferralCodeCreate = "referral_code.create"
+	AuditActionReferralCodeDelete = "referral_code.delete"
+	AuditActionReferralCodePublic = "referral_code.visibility"
+	AuditActionReferralCreate     = "referral.create"
+	AuditActionReferralApprove    = "referral.approve"
+	AuditActionAPIKeyCreate       = "api_key.create"
+	AuditActionAPIKeyRevoke       = "api_key.revoke"
+	AuditActionTermsPublish       = "terms.publish"
+	AuditActionTermsAccept        = "terms.accept"
+)
+
+const (
+	AuditTargetUser     = "user"
+	AuditTargetReferral = "referral"
+	AuditTargetAPIKey   = "api_key"
+	AuditTargetTerms    = "terms"
+)
+
+// RequestMeta describes who made a request and from where. Controllers fill
+// it in and services pass it on to the audit log.
+type RequestMeta struct {
+	ActorID   *uint
+	IP        string
+	UserAgent string
+}
+
+// AuditEntry is a single record of the append-only audit log. Each entry
+// stores the hash of the previous one, so editing or removing an entry
+// breaks the chain from that point on.
+//
+// The IP, user agent and before/after values are personal data, so they are
+// not hashed into the chain directly but through DetailsHash, a salted hash
+// of them. Redacting an entry clears them together with the salt and keeps
+// DetailsHash, which leaves the chain intact and the removed values
+// unrecoverable. Redaction can only remove the details, never change them: a
+// redacted entry that still has any of them fails verification. They are also encrypted at rest, and IPIndex, a blind index
+// of the IP, is what the log is searched by.
+type AuditEntry struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	ActorID     *uint           `gorm:"index" json:"actor_id,omitempty"`
+	Action      string          `gorm:"index;not null" json:"action"`
+	TargetType  string          `gorm:"index:idx_audit_entries_target" json:"target_type,omitempty"`
+	TargetID    *uint           `gorm:"index:idx_audit_entries_target" json:"target_id,omitempty"`
+	IP          string          `gorm:"serializer:pii" json:"ip,omitempty"`
+	IPIndex     string          `gorm:"index" json:"-"`
+	UserAgent   string          `gorm:"serializer:pii" json:"user_agent,omitempty"`
+	Before      json.RawMessage `gorm:"type:text;serializer:pii" json:"before,omitempty" swaggertype:"object"`
+	After       json.RawMessage `gorm:"type:text;serializer:pii" json:"after,omitempty" swaggertype:"object"`
+	DetailsSalt string          `json:"-"`
+	DetailsHash string          `json:"details_hash,omitempty"`
+	RedactedAt  *time.Time      `json:"redacted_at,omitempty"`
+	PrevHash    string          `gorm:"not null" json:"prev_hash"`
+	Hash        string          `gorm:"uniqueIndex;not null" json:"hash"`
+	CreatedAt   time.Time       `gorm:"index" json:"created_at"`
+}
+
+// SealDetails salts and hashes the personal data of a new entry.
+func (e *AuditEntry) SealDetails() error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	e.DetailsSalt = hex.EncodeToString(salt)
+	e.DetailsHash = e.ComputeDetailsHash()
+	return nil
+}
+
+// ComputeDetailsHash hashes the salt, IP, user agent and before/after values.
+// It does not match DetailsHash once the entry has been redacted.
+func (e *AuditEntry) ComputeDetailsHash() string {
+	return hashFields(e.DetailsSalt, e.IP, e.UserAgent, string(e.Before), string(e.After))
+}
+
+// DetailsIntact reports whether the details still match DetailsHash, or, for
+// a redacted entry, have all been removed. Entries without DetailsHash have
+// their details covered by Hash.
+func (e *AuditEntry) DetailsIntact() bool {
+	if e.DetailsHash == "" {
+		return true
+	}
+	if e.RedactedAt != nil {
+		return e.IP == "" && e.IPIndex == "" && e.UserAgent == "" &&
+			len(e.Before) == 0 && len(e.After) == 0 && e.DetailsSalt == ""
+	}
+	return e.ComputeDetailsHash() == e.DetailsHash
+}
+
+// ComputeHash hashes the contents of the entry together with the previous
+// hash. CreatedAt is truncated to microseconds, the precision Postgres keeps.
+// Entries written before DetailsHash existed hash their details directly and
+// cannot be redacted.
+func (e *AuditEntry) ComputeHash() string {
+	var actorID, targetID string
+	if e.ActorID != nil {
+		actorID = strconv.FormatUint(uint64(*e.ActorID), 10)
+	}
+	if e.TargetID != nil {
+		targetID = strconv.FormatUint(uint64(*e.TargetID), 10)
+	}
+	createdAt := e.CreatedAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+
+	if e.DetailsHash == "" {
+		return hashFields(e.PrevHash, actorID, e.Action, e.TargetType, targetID,
+			e.IP, e.UserAgent, string(e.Before), string(e.After), createdAt)
+	}
+	return hashFields("v2", e.PrevHash, actorID, e.Action, e.TargetType, targetID, e.DetailsHash, createdAt)
+}
+
+func hashFields(fields ...string) string {
+	// Lengths are included so that moving text between fields changes the hash.
+	var b strings.Builder
+	for _, field := range fields {
+		b.WriteString(strconv.Itoa(len(field)))
+		b.WriteByte(':')
+		b.WriteString(field)
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+type AuditVerification struct {
+	Valid   bool  `json:"valid"`
+	Checked int64 `json:"checked"`
+	// BrokenAt is the ID of the first entry whose hash does not match.
+	BrokenAt *uint `json:"broken_at,omitempty"`
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AudienceAll               = "all"
+	AudienceVerifiedReferrers = "verified_referrers"
+)
+
+// Campaign groups referral codes under common rules. Rewards are in minor
+// currency units and are granted to the referrer and the referred user for
+// every referral made with one of the campaign's codes. New users may be
+// limited to AllowedDomains, if set, and kept out by BlockedDomains.
+type Campaign struct {
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	Name            string         `gorm:"not null" json:"name"`
+	Description     string         `json:"description,omitempty"`
+	StartsAt        *time.Time     `json:"starts_at,omitempty"`
+	EndsAt          *time.Time     `json:"ends_at,omitempty"`
+	ReferrerReward  int64          `gorm:"not null;default:0" json:"referrer_reward"`
+	RefereeReward   int64          `gorm:"not null;default:0" json:"referee_reward"`
+	MaxParticipants int            `gorm:"not null;default:0" json:"max_participants"`
+	TargetAudience  string         `gorm:"not null;default:all" json:"target_audience"`
+	AllowedDomains  []string       `gorm:"serializer:json;type:text" json:"allowed_domains,omitempty"`
+	BlockedDomains  []string       `gorm:"serializer:json;type:text" json:"blocked_domains,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (c *Campaign) Started(now time.Time) bool {
+	return c.StartsAt == nil || !c.StartsAt.After(now)
+}
+
+func (c *Campaign) Ended(now time.Time) bool {
+	return c.EndsAt != nil && !c.EndsAt.After(now)
+}
+
+type DailyCount struct {
+	Date  string `json:"date" example:"2024-05-01"`
+	Count int64  `json:"count" example:"12"`
+}
+
+type CampaignStats struct {
+	CampaignID      uint         `json:"campaign_id"`
+	Referrals       int64        `json:"referrals"`
+	UniqueReferrers int64        `json:"unique_referrers"`
+	CodesIssued     int64        `json:"codes_issued"`
+	CodesRedeemed   int64        `json:"codes_redeemed"`
+	RewardsTotal    int64        `json:"rewards_total"`
+	DailyReferrals  []DailyCount `json:"daily_referrals"`
+}
+
+// PromoCode is a referral code that exists independently of User.ReferralCode,
+// e.g. one of a batch of single-use codes printed for a campaign. Referrals
+// made with it are attributed to OwnerID.
+type PromoCode struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Code       string     `gorm:"uniqueIndex;not null" json:"code"`
+	CampaignID *uint      `gorm:"index" json:"campaign_id,omitempty"`
+	OwnerID    uint       `gorm:"index;not null" json:"owner_id"`
+	BatchID    *uint      `gorm:"index" json:"batch_id,omitempty"`
+	MaxUses    int        `gorm:"not null;default:1" json:"max_uses"`
+	UsedCount  int        `gorm:"not null;default:0" json:"used_count"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func (p *PromoCode) Usable(now time.Time) bool {
+	if p.RevokedAt != nil || p.UsedCount >= p.MaxUses {
+		return false
+	}
+	return p.ExpiresAt == nil || p.ExpiresAt.After(now)
+}
+
+const (
+	CodeBatchStatusPending   = "pending"
+	CodeBatchStatusRunning   = "running"
+	CodeBatchStatusCompleted = "completed"
+	CodeBatchStatusFailed    = "failed"
+)
+
+type CodeBatch struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	CampaignID  uint       `gorm:"index;not null" json:"campaign_id"`
+	OwnerID     uint       `gorm:"not null" json:"owner_id"`
+	CreatedBy   uint       `json:"created_by"`
+	Requested   int        `gorm:"not null" json:"requested"`
+	Generated   int        `gorm:"not null;default:0" json:"generated"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Status      string     `gorm:"index;not null;default:pending" json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// LeaseOwner identifies the instance generating the codes, and
+	// LeaseUntil is when it loses its claim on the batch unless it renews it.
+	LeaseOwner string     `json:"-"`
+	LeaseUntil *time.Time `json:"-"`
+}
+
+type CodeImportError struct {
+	Row   int    `json:"row" example:"3"`
+	Code  string `json:"code,omitempty" example:"SPRING-0042"`
+	Error string `json:"error" example:"unknown owner email"`
+}
+
+type CodeImportReport struct {
+	DryRun    bool              `json:"dry_run"`
+	TotalRows int               `json:"total_rows"`
+	ValidRows int               `json:"valid_rows"`
+	Imported  int               `json:"imported"`
+	Errors    []CodeImportError `json:"errors"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// EmailChange is a requested switch to a new address, waiting for the user to
+// confirm it from that address. Only a hash of the confirmation token is kept.
+type EmailChange struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	NewEmail  string    `gorm:"not null;serializer:pii"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Provider  string    `gorm:"uniqueIndex:idx_user_identities_subject;not null" json:"provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_user_identities_subject;not null" json:"subject"`
+	Email     string    `gorm:"serializer:pii" json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OAuthState remembers a login started with an identity provider until the
+// user comes back, including the referral code to attribute a signup to and
+// the terms version accepted with it.
+type OAuthState struct {
+	ID           uint   `gorm:"primaryKey"`
+	State        string `gorm:"uniqueIndex;not null"`
+	Provider     string `gorm:"not null"`
+	Nonce        string `gorm:"not null"`
+	CodeVerifier string `gorm:"not null"`
+	ReferralCode string
+	TermsVersion string
+	ExpiresAt    time.Time `gorm:"index;not null"`
+	CreatedAt    time.Time
+}
@@ -0,0 +1,22 @@
+package models
+
+type ReferrerStats struct {
+	UserID        uint
+	Email         string `gorm:"serializer:pii"`
+	DisplayName   string
+	ReferralCount int64
+}
+
+type LeaderboardEntry struct {
+	Rank          int    `json:"rank" example:"1"`
+	DisplayName   string `json:"display_name" example:"jo***@example.com"`
+	ReferralCount int64  `json:"referral_count" example:"42"`
+}
+
+type Leaderboard struct {
+	Period   string             `json:"period" example:"month"`
+	Page     int                `json:"page" example:"1"`
+	PageSize int                `json:"page_size" example:"10"`
+	Total    int64              `json:"total" example:"120"`
+	Entries  []LeaderboardEntry `json:"entries"`
+}
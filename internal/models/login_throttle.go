@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+	LoginThrottleAccount = "account"
+	LoginThrottleIP      = "ip"
+)
+
+// LoginThrottle counts recent failed logins for an account (keyed by email,
+// so unknown addresses are throttled too) or a client IP. Subject holds the
+// blind index of the email or IP, not the value itself.
+type LoginThrottle struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Scope         string     `gorm:"uniqueIndex:idx_login_throttles_subject;not null" json:"scope"`
+	Subject       string     `gorm:"uniqueIndex:idx_login_throttles_subject;not null" json:"subject"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// MagicLink records an issued login link so that it can be used only once.
+type MagicLink struct {
+	ID        uint      `gorm:"primaryKey"`
+	JTI       string    `gorm:"uniqueIndex;not null"`
+	UserID    uint      `gorm:"index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
@@ -3,26 +3,114 @@ package models
 import (
 	"time"
 
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+	"github.com/serlenario/referral-system/internal/pii"
 	"gorm.io/gorm"
 )
 
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusBanned    = "banned"
+	// UserStatusDeleted marks an account whose personal data has been erased.
+	// The row is kept so that referrals and rewards still add up.
+	UserStatusDeleted = "deleted"
+)
+
 type User struct {
-	ID             uint           `gorm:"primaryKey" json:"id"`
-	Email          string         `gorm:"unique;not null" json:"email"`
-	PasswordHash   string         `json:"-"`
-	ReferralCode   string         `gorm:"unique" json:"referral_code"`
-	ReferralExpiry time.Time      `json:"referral_expiry"`
-	CreatedAt      time.Time      `json:"created_at"`
-	UpdatedAt      time.Time      `json:"updated_at"`
-	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
-	Referrals      []Referral     `json:"referrals,omitempty" gorm:"foreignKey:ReferredBy"`
+	ID                 uint           `gorm:"primaryKey" json:"id"`
+	Email              string         `gorm:"not null;serializer:pii" json:"email"`
+	EmailIndex         string         `gorm:"uniqueIndex" json:"-"`
+	PasswordHash       string         `json:"-"`
+	ReferralCode       string         `gorm:"unique" json:"referral_code"`
+	ReferralExpiry     time.Time      `json:"referral_expiry"`
+	DisplayName        string         `json:"display_name,omitempty"`
+	LeaderboardOptOut  bool           `gorm:"not null;default:false" json:"leaderboard_opt_out"`
+	EmailVerifiedAt    *time.Time     `json:"email_verified_at,omitempty"`
+	EmailFlag          string         `json:"email_flag,omitempty"`
+	Role               string         `gorm:"not null;default:user" json:"role"`
+	Status             string         `gorm:"index;not null;default:active" json:"status"`
+	StatusReason       string         `json:"status_reason,omitempty"`
+	StatusExpiresAt    *time.Time     `json:"status_expires_at,omitempty"`
+	StatusChangedBy    *uint          `json:"status_changed_by,omitempty"`
+	StatusChangedAt    *time.Time     `json:"status_changed_at,omitempty"`
+	ReferralCampaignID *uint          `json:"referral_campaign_id,omitempty"`
+	ReferralCodePublic bool           `gorm:"not null;default:false" json:"referral_code_public"`
+	DeletionDueAt      *time.Time     `gorm:"index" json:"deletion_due_at,omitempty"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
+	Referrals          []Referral     `json:"referrals,omitempty" gorm:"foreignKey:ReferredBy"`
+}
+
+const (
+	ReferralStatusPending   = "pending"
+	ReferralStatusQualified = "qualified"
+	ReferralStatusRejected  = "rejected"
+)
+
+// IsActive reports whether the user may log in and use their tokens. A
+// suspension with an expiry lifts itself once the expiry has passed.
+func (u *User) IsActive(now time.Time) bool {
+	switch u.Status {
+	case UserStatusActive:
+		return true
+	case UserStatusSuspended:
+		return u.StatusExpiresAt != nil && !u.StatusExpiresAt.After(now)
+	default:
+		return false
+	}
+}
+
+// CanRefer reports whether the user's referral code may be used. Accounts
+// with DeletionDueAt set are waiting to be deleted and stop referring
+// right away; logging in before then cancels the deletion.
+func (u *User) CanRefer(now time.Time) bool {
+	return u.IsActive(now) && u.DeletionDueAt == nil
+}
+
+// BeforeSave keeps the blind index in step with the email address, which is
+// stored encrypted and cannot be searched itself. The index is taken of the
+// normalised address, so aliases of an address find the same account.
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	index, err := pii.BlindIndex(emailpolicy.Normalize(u.Email))
+	if err != nil {
+		return err
+	}
+	u.EmailIndex = index
+	return nil
+}
+
+// UserStatusChange records every status change of an account and who made it.
+type UserStatusChange struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	Status    string     `gorm:"not null" json:"status"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ChangedBy uint       `json:"changed_by"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 type Referral struct {
-	ID         uint           `gorm:"primaryKey" json:"id"`
-	ReferredID uint           `json:"referred_id"`
-	ReferredBy uint           `json:"referred_by"`
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	ReferredID   uint           `json:"referred_id"`
+	ReferredBy   uint           `gorm:"index:idx_referrals_referrer_created" json:"referred_by"`
+	ReferralCode string         `gorm:"index" json:"referral_code"`
+	CampaignID   *uint          `gorm:"index" json:"campaign_id,omitempty"`
+	Status       string         `gorm:"index;not null;default:qualified" json:"status"`
+	CreatedAt    time.Time      `gorm:"index:idx_referrals_referrer_created" json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	Referred     *User          `gorm:"foreignKey:ReferredID" json:"-"`
+}
+
+type ReferralDetails struct {
+	ID                 uint      `json:"id"`
+	ReferralCode       string    `json:"referral_code"`
+	Status             string    `json:"status" example:"qualified"`
+	CreatedAt          time.Time `json:"created_at"`
+	ReferredEmail      string    `json:"referred_email" example:"jo***@example.com"`
+	ReferredSignedUpAt time.Time `json:"referred_signed_up_at"`
+	ReferredVerified   bool      `json:"referred_verified"`
 }
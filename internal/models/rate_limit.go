@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// RateLimitBucket is a token bucket shared between instances.
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"index;not null"`
+}
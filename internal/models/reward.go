@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+const (
+	RewardKindReferrer = "referrer"
+	RewardKindReferee  = "referee"
+)
+
+const (
+	RewardStatusPending = "pending"
+	RewardStatusGranted = "granted"
+	RewardStatusVoid    = "void"
+)
+
+type Reward struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"index;not null" json:"user_id"`
+	ReferralID uint      `gorm:"index;not null" json:"referral_id"`
+	CampaignID *uint     `gorm:"index" json:"campaign_id,omitempty"`
+	Kind       string    `gorm:"not null" json:"kind"`
+	Amount     int64     `gorm:"not null" json:"amount"`
+	Status     string    `gorm:"index;not null;default:granted" json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
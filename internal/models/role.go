@@ -0,0 +1,69 @@
+package models
+
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+const (
+	PermissionUsersRead       = "users:read"
+	PermissionUsersSuspend    = "users:suspend"
+	PermissionUsersRoles      = "users:roles"
+	PermissionReferralsRead   = "referrals:read"
+	PermissionReferralsReview = "referrals:review"
+	PermissionCodesRevoke     = "codes:revoke"
+	PermissionCampaignsManage = "campaigns:manage"
+	PermissionExportsRead     = "exports:read"
+	PermissionAuditRead       = "audit:read"
+	PermissionTermsManage     = "terms:manage"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser: {},
+	RoleSupport: {
+		PermissionUsersRead,
+		PermissionUsersSuspend,
+		PermissionReferralsRead,
+		PermissionReferralsReview,
+		PermissionCodesRevoke,
+	},
+	RoleAdmin: {
+		PermissionUsersRead,
+		PermissionUsersSuspend,
+		PermissionUsersRoles,
+		PermissionReferralsRead,
+		PermissionReferralsReview,
+		PermissionCodesRevoke,
+		PermissionCampaignsManage,
+		PermissionExportsRead,
+		PermissionAuditRead,
+		PermissionTermsManage,
+	},
+}
+
+// roleRanks orders the roles by how much they may do.
+var roleRanks = map[string]int{
+	RoleUser:    0,
+	RoleSupport: 1,
+	RoleAdmin:   2,
+}
+
+// Outranks reports whether role is strictly higher than other.
+func Outranks(role, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
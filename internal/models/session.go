@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Session is a login on one device. Every access token names its session, so
+// ending the session logs that device out even though the token has not
+// expired yet.
+type Session struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"-"`
+	Device      string     `json:"device"`
+	UserAgent   string     `json:"user_agent"`
+	IP          string     `gorm:"serializer:pii" json:"ip"`
+	LoginMethod string     `json:"login_method"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastSeenAt  time.Time  `json:"last_seen_at"`
+	ExpiresAt   time.Time  `gorm:"index;not null" json:"expires_at"`
+	RevokedAt   *time.Time `json:"-"`
+	// Current marks the session of the token the list was requested with.
+	Current bool `gorm:"-" json:"current"`
+}
+
+// Active reports whether tokens of the session are still accepted.
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// TermsVersion is a published version of the referral programme terms. The
+// newest version is the current one, and users who have not accepted it do
+// not receive rewards.
+type TermsVersion struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Version     string    `gorm:"uniqueIndex;not null" json:"version" example:"2024-05"`
+	URL         string    `gorm:"not null" json:"url" example:"https://example.com/terms/2024-05"`
+	Summary     string    `json:"summary,omitempty"`
+	PublishedAt time.Time `gorm:"autoCreateTime" json:"published_at"`
+}
+
+// TermsAcceptance records that a user accepted a version of the terms, and
+// from where.
+type TermsAcceptance struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	UserID     uint      `gorm:"uniqueIndex:idx_terms_acceptances_user_version;not null" json:"-"`
+	Version    string    `gorm:"uniqueIndex:idx_terms_acceptances_user_version;not null" json:"version"`
+	IP         string    `gorm:"serializer:pii" json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	AcceptedAt time.Time `gorm:"not null" json:"accepted_at"`
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Message is a notification addressed to a user.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// LogNotifier writes notifications to the log. It is used when no delivery
+// channel is configured. Only the subject is logged, since bodies may contain
+// login links.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(msg Message) error {
+	log.Printf("notification to %s: %s", msg.To, msg.Subject)
+	return nil
+}
+
+// WebhookNotifier posts notifications as JSON to a URL, leaving delivery to
+// whatever service listens there.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Config selects how notifications are delivered: by email when SMTPAddr is
+// set, otherwise to WebhookURL, otherwise only to the log.
+type Config struct {
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+	WebhookURL   string
+}
+
+func New(cfg Config) Notifier {
+	switch {
+	case cfg.SMTPAddr != "":
+		return &SMTPNotifier{Addr: cfg.SMTPAddr, From: cfg.SMTPFrom, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword}
+	case cfg.WebhookURL != "":
+		return NewWebhookNotifier(cfg.WebhookURL)
+	default:
+		return LogNotifier{}
+	}
+}
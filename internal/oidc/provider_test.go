@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testClientID = "client-id"
+
+// testIssuer is an OpenID provider stub serving a discovery document and the
+// public half of its signing key under the key ID "k1".
+type testIssuer struct {
+	server      *httptest.Server
+	key         *rsa.PrivateKey
+	jwksFetches atomic.Int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.jwksFetches.Add(1)
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kty: "RSA",
+			Kid: "k1",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (i *testIssuer) provider() *Provider {
+	return NewProvider(Config{Name: "test", Issuer: i.server.URL, ClientID: testClientID})
+}
+
+// claims returns valid claims for the nonce "n0nce".
+func (i *testIssuer) claims() *Claims {
+	return &Claims{
+		Nonce: "n0nce",
+		Email: "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.server.URL,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{testClientID},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func (i *testIssuer) sign(t *testing.T, method jwt.SigningMethod, kid string, claims *Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	var key interface{} = i.key
+	switch method {
+	case jwt.SigningMethodHS256:
+		key = []byte("shared secret")
+	case jwt.SigningMethodNone:
+		key = jwt.UnsafeAllowNoneSignatureType
+	}
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return raw
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	for _, method := range []jwt.SigningMethod{jwt.SigningMethodRS256, jwt.SigningMethodRS512} {
+		raw := issuer.sign(t, method, "k1", issuer.claims())
+		claims, err := issuer.provider().VerifyIDToken(raw, "n0nce")
+		if err != nil {
+			t.Fatalf("%s: VerifyIDToken: %v", method.Alg(), err)
+		}
+		if claims.Subject != "subject-1" || claims.Email != "user@example.com" {
+			t.Fatalf("%s: claims = %+v", method.Alg(), claims)
+		}
+	}
+}
+
+func TestVerifyIDTokenRejectsClaims(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+	}{
+		{"wrong audience", func(c *Claims) { c.Audience = jwt.ClaimStrings{"other-client"} }},
+		{"no audience", func(c *Claims) { c.Audience = nil }},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "https://evil.example" }},
+		{"missing exp", func(c *Claims) { c.ExpiresAt = nil }},
+		{"expired", func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }},
+		{"no subject", func(c *Claims) { c.Subject = "" }},
+	}
+	for _, tt := range tests {
+		claims := issuer.claims()
+		tt.modify(claims)
+		raw := issuer.sign(t, jwt.SigningMethodRS256, "k1", claims)
+		if _, err := issuer.provider().VerifyIDToken(raw, "n0nce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: VerifyIDToken = %v, want ErrInvalidIDToken", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyIDTokenRejectsNonce(t *testing.T) {
+	issuer := newTestIssuer(t)
+	raw := issuer.sign(t, jwt.SigningMethodRS256, "k1", issuer.claims())
+
+	for _, nonce := range []string{"other", ""} {
+		if _, err := issuer.provider().VerifyIDToken(raw, nonce); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("nonce %q: VerifyIDToken = %v, want ErrInvalidIDToken", nonce, err)
+		}
+	}
+}
+
+func TestVerifyIDTokenRejectsSignature(t *testing.T) {
+	issuer := newTestIssuer(t)
+	valid := issuer.sign(t, jwt.SigningMethodRS256, "k1", issuer.claims())
+
+	tests := map[string]string{
+		"unknown kid": issuer.sign(t, jwt.SigningMethodRS256, "k2", issuer.claims()),
+		"HS256":       issuer.sign(t, jwt.SigningMethodHS256, "k1", issuer.claims()),
+		"none":        issuer.sign(t, jwt.SigningMethodNone, "k1", issuer.claims()),
+		// An RS256 signature presented as another algorithm.
+		"ES256 header": withAlg(t, valid, "ES256"),
+		"PS256 header": withAlg(t, valid, "PS256"),
+	}
+	for name, raw := range tests {
+		if _, err := issuer.provider().VerifyIDToken(raw, "n0nce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: VerifyIDToken = %v, want ErrInvalidIDToken", name, err)
+		}
+	}
+}
+
+// withAlg rewrites the alg header of a signed token, keeping its signature.
+func withAlg(t *testing.T, raw, alg string) string {
+	t.Helper()
+	parts := strings.Split(raw, ".")
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := map[string]interface{}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		t.Fatal(err)
+	}
+	header["alg"] = alg
+	data, _ = json.Marshal(header)
+	parts[0] = base64.RawURLEncoding.EncodeToString(data)
+	return strings.Join(parts, ".")
+}
+
+func TestUnknownKidRefetchIsLimited(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+
+	for i := 0; i < 5; i++ {
+		raw := issuer.sign(t, jwt.SigningMethodRS256, "unknown", issuer.claims())
+		if _, err := provider.VerifyIDToken(raw, "n0nce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Fatalf("VerifyIDToken = %v, want ErrInvalidIDToken", err)
+		}
+	}
+	if got := issuer.jwksFetches.Load(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want once", got)
+	}
+
+	raw := issuer.sign(t, jwt.SigningMethodRS256, "k1", issuer.claims())
+	if _, err := provider.VerifyIDToken(raw, "n0nce"); err != nil {
+		t.Fatalf("known key after unknown ones: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// BreachChecker reports whether a password is known from a data breach.
+type BreachChecker interface {
+	Breached(password string) (bool, error)
+}
+
+// RangeProvider implements k-anonymity lookups: given the first five hex
+// characters of a password's SHA-1 hash it returns the remaining characters
+// of every breached hash with that prefix, so the password itself never
+// leaves the process.
+type RangeProvider interface {
+	Range(prefix string) ([]string, error)
+}
+
+const hashPrefixLength = 5
+
+type rangeChecker struct {
+	provider RangeProvider
+}
+
+// NewBreachChecker checks passwords against a range provider.
+func NewBreachChecker(provider RangeProvider) BreachChecker {
+	return &rangeChecker{provider: provider}
+}
+
+func (c *rangeChecker) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := c.provider.Range(hash[:hashPrefixLength])
+	if err != nil {
+		return false, err
+	}
+
+	for _, suffix := range suffixes {
+		if strings.EqualFold(suffix, hash[hashPrefixLength:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FileRangeProvider looks hashes up in a local file of SHA-1 hashes sorted
+// in ascending order, one per line and optionally followed by ":count", as in
+// the ordered-by-hash download of Pwned Passwords. The file is searched in
+// place, so it may be far larger than memory.
+type FileRangeProvider struct {
+	Path string
+}
+
+func (p *FileRangeProvider) Range(prefix string) ([]string, error) {
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.ToUpper(prefix)
+
+	// Find the first line whose hash sorts at or after the prefix.
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		hash, err := hashLineAt(f, mid)
+		if err != nil {
+			return nil, err
+		}
+		if hash == "" || hash >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(f, lo, info.Size()-lo))
+	if lo > 0 {
+		if err := skipPartialLine(f, lo, reader); err != nil {
+			return nil, err
+		}
+	}
+
+	var suffixes []string
+	for {
+		line, err := reader.ReadString('\n')
+		hash := hashField(line)
+		if hash != "" {
+			if !strings.HasPrefix(hash, prefix) {
+				break
+			}
+			suffixes = append(suffixes, hash[len(prefix):])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return suffixes, nil
+}
+
+// hashLineAt returns the hash on the first line starting at or after offset,
+// or "" at the end of the file.
+func hashLineAt(f *os.File, offset int64) (string, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(f, offset, size-offset))
+	if offset > 0 {
+		if err := skipPartialLine(f, offset, reader); err != nil {
+			return "", err
+		}
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return hashField(line), nil
+}
+
+// skipPartialLine advances reader, positioned at offset, to the next line
+// start unless offset already is one.
+func skipPartialLine(f *os.File, offset int64, reader *bufio.Reader) error {
+	prev := make([]byte, 1)
+	if _, err := f.ReadAt(prev, offset-1); err != nil {
+		return err
+	}
+	if prev[0] == '\n' {
+		return nil
+	}
+
+	_, err := reader.ReadString('\n')
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func hashField(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
+
+// HTTPRangeProvider queries a range API compatible with Pwned Passwords.
+type HTTPRangeProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewHTTPRangeProvider(baseURL string) *HTTPRangeProvider {
+	return &HTTPRangeProvider{BaseURL: baseURL, Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (p *HTTPRangeProvider) Range(prefix string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(p.BaseURL, "/")+"/"+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Padding hides the real number of matches from anyone watching the traffic.
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("breach range API returned %s", resp.Status)
+	}
+
+	var suffixes []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		suffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if suffix == "" || count == "0" {
+			continue
+		}
+		suffixes = append(suffixes, suffix)
+	}
+	return suffixes, scanner.Err()
+}
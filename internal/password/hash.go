@@ -0,0 +1,174 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// Hasher is one password hashing algorithm. Encoded hashes carry the
+// algorithm and its parameters, so a hash stays verifiable after the
+// configuration changes.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// Recognizes reports whether encoded was produced by this algorithm.
+	Recognizes(encoded string) bool
+	// Outdated reports whether encoded uses weaker parameters than the
+	// hasher is configured with.
+	Outdated(encoded string) bool
+}
+
+// BcryptMaxBytes is the longest password bcrypt accepts.
+const BcryptMaxBytes = 72
+
+// Bcrypt hashes with bcrypt at the given cost. bcrypt only uses the first
+// 72 bytes of a password and refuses longer ones.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hash), err
+}
+
+func (b Bcrypt) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b Bcrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b Bcrypt) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < b.Cost
+}
+
+// Argon2id hashes with Argon2id and encodes the result in the PHC string
+// format: $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<hash>.
+type Argon2id struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2id) Verify(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+func (a Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) Outdated(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.Memory || params.Time < a.Time || params.Threads < a.Threads ||
+		uint32(len(salt)) < a.SaltLen || uint32(len(key)) < a.KeyLen
+}
+
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	// argon2.IDKey panics on zero passes or threads.
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Memory < 1 || params.Time < 1 || params.Threads < 1 {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("argon2 hash has no key")
+	}
+	return params, salt, key, nil
+}
+
+// MultiHasher hashes new passwords with Current and still verifies hashes of
+// the Legacy algorithms, so stored hashes can be upgraded one login at a time.
+type MultiHasher struct {
+	Current Hasher
+	Legacy  []Hasher
+}
+
+func NewMultiHasher(current Hasher, legacy ...Hasher) *MultiHasher {
+	return &MultiHasher{Current: current, Legacy: legacy}
+}
+
+func (m *MultiHasher) Hash(password string) (string, error) {
+	return m.Current.Hash(password)
+}
+
+// Verify checks the password and reports whether the stored hash should be
+// replaced by a fresh one from Current.
+func (m *MultiHasher) Verify(encoded, password string) (ok, rehash bool, err error) {
+	if m.Current.Recognizes(encoded) {
+		ok, err = m.Current.Verify(encoded, password)
+		return ok, ok && m.Current.Outdated(encoded), err
+	}
+
+	for _, hasher := range m.Legacy {
+		if hasher.Recognizes(encoded) {
+			ok, err = hasher.Verify(encoded, password)
+			return ok, ok, err
+		}
+	}
+	return false, false, ErrUnknownHashFormat
+}
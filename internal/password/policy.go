@@ -0,0 +1,165 @@
+// Package password validates new passwords against a configurable policy and
+// lists of common and breached passwords.
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrWeakPassword     = errors.New("password does not meet the requirements")
+	ErrBreachedPassword = errors.New("password has appeared in a data breach, please choose another one")
+)
+
+//go:embed common.txt
+var commonPasswords string
+
+// Policy describes what a new password must look like. Character classes are
+// lowercase letters, uppercase letters, digits and everything else.
+type Policy struct {
+	MinLength      int
+	MaxLength      int
+	MinCharClasses int
+	// MaxBytes limits the encoded length, for hash algorithms that cannot
+	// take longer passwords. Zero means no limit.
+	MaxBytes int
+	// Banned holds lowercased passwords that are always rejected.
+	Banned map[string]struct{}
+}
+
+// NewPolicy returns a policy banning the built-in list of common passwords
+// plus those in bannedFile, if given.
+func NewPolicy(minLength, maxLength, minCharClasses int, bannedFile string) (Policy, error) {
+	policy := Policy{
+		MinLength:      minLength,
+		MaxLength:      maxLength,
+		MinCharClasses: minCharClasses,
+		Banned:         make(map[string]struct{}),
+	}
+
+	if err := policy.addBanned(strings.NewReader(commonPasswords)); err != nil {
+		return policy, err
+	}
+
+	if bannedFile != "" {
+		f, err := os.Open(bannedFile)
+		if err != nil {
+			return policy, err
+		}
+		defer f.Close()
+
+		if err := policy.addBanned(f); err != nil {
+			return policy, fmt.Errorf("%s: %w", bannedFile, err)
+		}
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) addBanned(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.Banned[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Check reports the first requirement the password fails. The email is used
+// to reject passwords built from the user's own address.
+func (p Policy) Check(password, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrWeakPassword, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w: it must be at most %d characters long", ErrWeakPassword, p.MaxLength)
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		return fmt.Errorf("%w: it must be at most %d bytes long", ErrWeakPassword, p.MaxBytes)
+	}
+
+	if classes := charClasses(password); classes < p.MinCharClasses {
+		return fmt.Errorf("%w: it must mix at least %d of lowercase letters, uppercase letters, digits and symbols", ErrWeakPassword, p.MinCharClasses)
+	}
+
+	lower := strings.ToLower(password)
+	if _, banned := p.Banned[lower]; banned {
+		return fmt.Errorf("%w: it is too common", ErrWeakPassword)
+	}
+
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); len(local) >= 3 && strings.Contains(lower, local) {
+		return fmt.Errorf("%w: it must not contain your email address", ErrWeakPassword)
+	}
+
+	return nil
+}
+
+func charClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// Validator applies a policy and, if a breach checker is set, rejects
+// passwords known from breaches.
+type Validator struct {
+	policy Policy
+	breach BreachChecker
+}
+
+func NewValidator(policy Policy, breach BreachChecker) *Validator {
+	return &Validator{policy: policy, breach: breach}
+}
+
+func (v *Validator) Validate(password, email string) error {
+	if err := v.policy.Check(password, email); err != nil {
+		return err
+	}
+
+	if v.breach == nil {
+		return nil
+	}
+
+	// The breach check is a second line of defence; an unavailable provider
+	// should not stop people from signing up.
+	breached, err := v.breach.Breached(password)
+	if err != nil {
+		log.Printf("password breach check: %v", err)
+		return nil
+	}
+	if breached {
+		return ErrBreachedPassword
+	}
+	return nil
+}
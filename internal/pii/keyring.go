@@ -0,0 +1,223 @@
+// Package pii encrypts personal data before it is written to the database.
+//
+// Values are sealed with envelope encryption: every value gets a fresh data
+// key, which is itself encrypted with one of the configured master keys. The
+// stored text names the master key, so keys can be rotated by adding a new
+// one, making it active and re-encrypting the existing rows.
+package pii
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// prefix marks encrypted values. Anything else is plaintext written before
+// encryption was enabled.
+const prefix = "pii:v1:"
+
+var ErrUnknownKey = errors.New("value is encrypted with an unknown key")
+
+// Keyring holds the master keys and the key of the blind index.
+type Keyring struct {
+	keys     map[string][]byte
+	active   string
+	indexKey []byte
+}
+
+// NewKeyring returns a keyring that encrypts with the key named active. With
+// no keys at all, values are stored in plaintext.
+func NewKeyring(keys map[string][]byte, active string, indexKey []byte) (*Keyring, error) {
+	if len(indexKey) == 0 {
+		return nil, errors.New("blind index key is required")
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	if len(keys) > 0 {
+		if _, ok := keys[active]; !ok {
+			return nil, fmt.Errorf("active key %q is not configured", active)
+		}
+	}
+	return &Keyring{keys: keys, active: active, indexKey: indexKey}, nil
+}
+
+// ParseKeys reads keys in the form id:base64key, separated by commas or
+// newlines. Empty lines and lines starting with # are skipped. The ids are
+// returned in order, so callers can default to the last one.
+func ParseKeys(r io.Reader) (map[string][]byte, []string, error) {
+	keys := map[string][]byte{}
+	var ids []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		for _, entry := range strings.Split(scanner.Text(), ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" || strings.HasPrefix(entry, "#") {
+				continue
+			}
+
+			id, encoded, ok := strings.Cut(entry, ":")
+			if !ok {
+				return nil, nil, fmt.Errorf("key entry %q is not id:base64key", entry)
+			}
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil {
+				return nil, nil, fmt.Errorf("key %q: %w", id, err)
+			}
+			if _, dup := keys[id]; dup {
+				return nil, nil, fmt.Errorf("key %q is listed twice", id)
+			}
+			keys[id] = key
+			ids = append(ids, id)
+		}
+	}
+	return keys, ids, scanner.Err()
+}
+
+// ParseKeysFile reads keys like ParseKeys from a file.
+func ParseKeysFile(path string) (map[string][]byte, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return ParseKeys(file)
+}
+
+// Enabled reports whether new values are encrypted.
+func (k *Keyring) Enabled() bool {
+	return k.active != ""
+}
+
+// Encrypt seals value under the active key. Empty values are stored as they
+// are, so optional columns stay empty.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if value == "" || !k.Enabled() {
+		return value, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealed, err := seal(dataKey, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+	// The key id is authenticated with the wrapped data key, so it cannot be
+	// swapped for another one.
+	wrapped, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.active + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value made by Encrypt. Plaintext values are returned as
+// they are.
+func (k *Keyring) Decrypt(stored string) (string, error) {
+	if !strings.HasPrefix(stored, prefix) {
+		return stored, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(stored, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	masterKey, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := open(masterKey, wrapped, []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	value, err := open(dataKey, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// Stale reports whether a stored value should be rewritten: it is plaintext
+// while encryption is enabled, or encrypted with a key that is no longer
+// active.
+func (k *Keyring) Stale(stored string) bool {
+	if stored == "" {
+		return false
+	}
+	if !strings.HasPrefix(stored, prefix) {
+		return k.Enabled()
+	}
+	keyID, _, _ := strings.Cut(strings.TrimPrefix(stored, prefix), ":")
+	return keyID != k.active
+}
+
+// BlindIndex returns a keyed hash of value. Equal values give equal hashes,
+// so the index can be searched and kept unique while the value itself is
+// stored encrypted.
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
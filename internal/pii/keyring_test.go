@@ -0,0 +1,148 @@
+package pii
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+	keys := map[string][]byte{}
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	k, err := NewKeyring(keys, active, []byte("index key"))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	k := testKeyring(t, "k1", "k1")
+
+	for _, value := range []string{"user@example.com", "203.0.113.7", `{"email":"a@b.c"}`, "ünïcödé"} {
+		stored, err := k.Encrypt(value)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", value, err)
+		}
+		if !strings.HasPrefix(stored, prefix+"k1:") || strings.Contains(stored, value) {
+			t.Fatalf("Encrypt(%q) = %q, want a value sealed under k1", value, stored)
+		}
+		got, err := k.Decrypt(stored)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if got != value {
+			t.Fatalf("Decrypt = %q, want %q", got, value)
+		}
+	}
+}
+
+func TestEncryptUsesFreshDataKeys(t *testing.T) {
+	k := testKeyring(t, "k1", "k1")
+
+	a, _ := k.Encrypt("same")
+	b, _ := k.Encrypt("same")
+	if a == b {
+		t.Fatal("encrypting the same value twice gave the same ciphertext")
+	}
+}
+
+func TestEncryptLeavesEmptyAndDisabledAsIs(t *testing.T) {
+	k := testKeyring(t, "k1", "k1")
+	if stored, _ := k.Encrypt(""); stored != "" {
+		t.Fatalf("Encrypt(\"\") = %q, want empty", stored)
+	}
+
+	plain := testKeyring(t, "")
+	if stored, _ := plain.Encrypt("user@example.com"); stored != "user@example.com" {
+		t.Fatalf("Encrypt without keys = %q, want plaintext", stored)
+	}
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	k := testKeyring(t, "k1", "k1")
+	got, err := k.Decrypt("user@example.com")
+	if err != nil || got != "user@example.com" {
+		t.Fatalf("Decrypt(plaintext) = %q, %v", got, err)
+	}
+}
+
+func TestDecryptUnknownKey(t *testing.T) {
+	old := testKeyring(t, "k1", "k1")
+	stored, _ := old.Encrypt("user@example.com")
+
+	k := testKeyring(t, "k2", "k2")
+	if _, err := k.Decrypt(stored); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Decrypt with unknown key: err = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	k := testKeyring(t, "k2", "k1", "k2")
+	stored, _ := k.Encrypt("user@example.com")
+
+	// Claiming another key id must fail, as the id is authenticated.
+	swapped := strings.Replace(stored, prefix+"k2:", prefix+"k1:", 1)
+	if _, err := k.Decrypt(swapped); err == nil {
+		t.Fatal("Decrypt accepted a value with a swapped key id")
+	}
+
+	// Flip a character away from the end, whose low bits may be padding.
+	i := len(stored) - 5
+	flipped := stored[:i] + string(stored[i]^1) + stored[i+1:]
+	if _, err := k.Decrypt(flipped); err == nil {
+		t.Fatal("Decrypt accepted a modified ciphertext")
+	}
+
+	if _, err := k.Decrypt(prefix + "k2:only-two"); err == nil {
+		t.Fatal("Decrypt accepted a malformed value")
+	}
+}
+
+func TestStale(t *testing.T) {
+	k1 := testKeyring(t, "k1", "k1", "k2")
+	k2 := testKeyring(t, "k2", "k1", "k2")
+	plain := testKeyring(t, "")
+
+	underK1, _ := k1.Encrypt("user@example.com")
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		stored  string
+		want    bool
+	}{
+		{"empty", k2, "", false},
+		{"plaintext with encryption", k2, "user@example.com", true},
+		{"plaintext without encryption", plain, "user@example.com", false},
+		{"active key", k1, underK1, false},
+		{"other key", k2, underK1, true},
+	}
+	for _, tt := range tests {
+		if got := tt.keyring.Stale(tt.stored); got != tt.want {
+			t.Errorf("%s: Stale = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	k := testKeyring(t, "k1", "k1")
+	other, err := NewKeyring(nil, "", []byte("other index key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.BlindIndex("a@b.c") != k.BlindIndex("a@b.c") {
+		t.Fatal("BlindIndex is not deterministic")
+	}
+	if k.BlindIndex("a@b.c") == k.BlindIndex("x@b.c") {
+		t.Fatal("different values have the same blind index")
+	}
+	if k.BlindIndex("a@b.c") == other.BlindIndex("a@b.c") {
+		t.Fatal("BlindIndex ignores the index key")
+	}
+}
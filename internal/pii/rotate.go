@@ -0,0 +1,199 @@
+package pii
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Column is a table column holding values written by the pii serializer.
+// IndexColumn, if set, holds the blind index of non-empty values, taken of
+// the value as returned by Normalize if that is set. Unique marks an index
+// that must not hold the same hash twice.
+type Column struct {
+	Table       string
+	Name        string
+	IndexColumn string
+	Normalize   func(string) string
+	Unique      bool
+}
+
+// RotateReport counts what Rotate did for one column. Collisions lists the
+// IDs of rows sharing a unique index, one group per index.
+type RotateReport struct {
+	Table      string   `json:"table"`
+	Column     string   `json:"column"`
+	Scanned    int      `json:"scanned"`
+	Rewritten  int      `json:"rewritten"`
+	Collisions [][]uint `json:"collisions,omitempty"`
+}
+
+type storedRow struct {
+	ID         uint
+	Value      *string
+	BlindIndex *string
+}
+
+// Rotate re-encrypts the values of col that are stale under the keyring,
+// batchSize rows at a time, and fills in missing blind indexes. Rows are
+// rewritten in place without touching their other columns, and only if the
+// value has not changed since it was read, so it is safe to run while the
+// application is serving requests, and to run again after an interruption.
+//
+// For a unique index, rows whose values would share an index, such as
+// aliases of one email address, are found first and reported. They are still
+// re-encrypted but keep their index until the collision is resolved.
+func (k *Keyring) Rotate(db *gorm.DB, col Column, batchSize int) (*RotateReport, error) {
+	report := &RotateReport{Table: col.Table, Column: col.Name}
+
+	var colliding map[uint]bool
+	if col.IndexColumn != "" && col.Unique {
+		var err error
+		if report.Collisions, err = k.collisions(db, col, batchSize); err != nil {
+			return report, err
+		}
+		colliding = map[uint]bool{}
+		for _, ids := range report.Collisions {
+			for _, id := range ids {
+				colliding[id] = true
+			}
+		}
+	}
+
+	selectCols := "id, " + col.Name + " AS value"
+	if col.IndexColumn != "" {
+		selectCols += ", " + col.IndexColumn + " AS blind_index"
+	}
+
+	var lastID uint
+	for {
+		var rows []storedRow
+		err := db.Table(col.Table).
+			Select(selectCols).
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(batchSize).
+			Scan(&rows).Error
+		if err != nil {
+			return report, err
+		}
+		if len(rows) == 0 {
+			return report, nil
+		}
+		lastID = rows[len(rows)-1].ID
+		report.Scanned += len(rows)
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				updates, err := k.rotateRow(row, col)
+				if err != nil {
+					return fmt.Errorf("%s %d: %w", col.Table, row.ID, err)
+				}
+				if colliding[row.ID] {
+					delete(updates, col.IndexColumn)
+				}
+				if len(updates) == 0 {
+					continue
+				}
+				result := tx.Table(col.Table).Where("id = ? AND "+col.Name+" = ?", row.ID, *row.Value).UpdateColumns(updates)
+				if result.Error != nil {
+					return result.Error
+				}
+				report.Rewritten += int(result.RowsAffected)
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+}
+
+// collisions groups the rows of col whose values have the same blind index,
+// and returns the groups of more than one row in order of their first ID.
+func (k *Keyring) collisions(db *gorm.DB, col Column, batchSize int) ([][]uint, error) {
+	byIndex := map[string][]uint{}
+	var order []string
+
+	var lastID uint
+	for {
+		var rows []storedRow
+		err := db.Table(col.Table).
+			Select("id, "+col.Name+" AS value").
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(batchSize).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		lastID = rows[len(rows)-1].ID
+
+		for _, row := range rows {
+			if row.Value == nil {
+				continue
+			}
+			value, err := k.Decrypt(*row.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s %d: %w", col.Table, row.ID, err)
+			}
+			index := k.indexOf(value, col)
+			if index == "" {
+				continue
+			}
+			if _, seen := byIndex[index]; !seen {
+				order = append(order, index)
+			}
+			byIndex[index] = append(byIndex[index], row.ID)
+		}
+	}
+
+	var groups [][]uint
+	for _, index := range order {
+		if ids := byIndex[index]; len(ids) > 1 {
+			groups = append(groups, ids)
+		}
+	}
+	return groups, nil
+}
+
+// indexOf returns the blind index of a decrypted value of col, or "" for an
+// empty value.
+func (k *Keyring) indexOf(value string, col Column) string {
+	if value == "" {
+		return ""
+	}
+	if col.Normalize != nil {
+		value = col.Normalize(value)
+	}
+	return k.BlindIndex(value)
+}
+
+func (k *Keyring) rotateRow(row storedRow, col Column) (map[string]interface{}, error) {
+	if row.Value == nil {
+		return nil, nil
+	}
+
+	value, err := k.Decrypt(*row.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if k.Stale(*row.Value) {
+		encrypted, err := k.Encrypt(value)
+		if err != nil {
+			return nil, err
+		}
+		updates[col.Name] = encrypted
+	}
+	if col.IndexColumn != "" {
+		if index := k.indexOf(value, col); index != "" && (row.BlindIndex == nil || *row.BlindIndex != index) {
+			updates[col.IndexColumn] = index
+		}
+	}
+	return updates, nil
+}
@@ -0,0 +1,71 @@
+package pii
+
+import (
+	"strings"
+	"testing"
+)
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestRotateRow(t *testing.T) {
+	old := testKeyring(t, "k1", "k1", "k2")
+	k := testKeyring(t, "k2", "k1", "k2")
+	col := Column{Table: "users", Name: "email", IndexColumn: "email_index", Normalize: strings.ToLower}
+
+	underOld, _ := old.Encrypt("User@Example.com")
+	underActive, _ := k.Encrypt("User@Example.com")
+	index := k.BlindIndex("user@example.com")
+
+	tests := []struct {
+		name        string
+		row         storedRow
+		reencrypted bool
+		reindexed   bool
+	}{
+		{"null", storedRow{ID: 1}, false, false},
+		{"plaintext", storedRow{ID: 2, Value: ptr("User@Example.com")}, true, true},
+		{"old key", storedRow{ID: 3, Value: ptr(underOld), BlindIndex: &index}, true, false},
+		{"up to date", storedRow{ID: 4, Value: ptr(underActive), BlindIndex: &index}, false, false},
+		{"wrong index", storedRow{ID: 5, Value: ptr(underActive), BlindIndex: ptr("stale")}, false, true},
+		{"empty", storedRow{ID: 6, Value: ptr("")}, false, false},
+	}
+	for _, tt := range tests {
+		updates, err := k.rotateRow(tt.row, col)
+		if err != nil {
+			t.Fatalf("%s: rotateRow: %v", tt.name, err)
+		}
+
+		encrypted, reencrypted := updates[col.Name].(string)
+		if reencrypted != tt.reencrypted {
+			t.Errorf("%s: re-encrypted = %v, want %v", tt.name, reencrypted, tt.reencrypted)
+		}
+		if reencrypted {
+			if k.Stale(encrypted) {
+				t.Errorf("%s: rewritten value is still stale", tt.name)
+			}
+			if got, _ := k.Decrypt(encrypted); got != "User@Example.com" {
+				t.Errorf("%s: rewritten value decrypts to %q", tt.name, got)
+			}
+		}
+
+		got, reindexed := updates[col.IndexColumn]
+		if reindexed != tt.reindexed {
+			t.Errorf("%s: reindexed = %v, want %v", tt.name, reindexed, tt.reindexed)
+		}
+		if reindexed && got != index {
+			t.Errorf("%s: index = %v, want the index of the normalised value", tt.name, got)
+		}
+	}
+}
+
+func TestRotateRowUnknownKey(t *testing.T) {
+	retired := testKeyring(t, "k0", "k0")
+	stored, _ := retired.Encrypt("user@example.com")
+
+	k := testKeyring(t, "k2", "k1", "k2")
+	if _, err := k.rotateRow(storedRow{ID: 1, Value: &stored}, Column{Name: "email"}); err == nil {
+		t.Fatal("rotateRow accepted a value under a key that is no longer configured")
+	}
+}
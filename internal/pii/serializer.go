@@ -0,0 +1,94 @@
+package pii
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"gorm.io/gorm/schema"
+)
+
+var current atomic.Pointer[Keyring]
+
+func init() {
+	schema.RegisterSerializer("pii", Serializer{})
+}
+
+// Use sets the keyring for the pii serializer and BlindIndex. It has to be
+// called before any model with encrypted fields is read or written.
+func Use(keyring *Keyring) {
+	current.Store(keyring)
+}
+
+func keyring() (*Keyring, error) {
+	k := current.Load()
+	if k == nil {
+		return nil, errors.New("pii: no keyring configured")
+	}
+	return k, nil
+}
+
+// BlindIndex hashes value with the keyring set by Use.
+func BlindIndex(value string) (string, error) {
+	k, err := keyring()
+	if err != nil {
+		return "", err
+	}
+	return k.BlindIndex(value), nil
+}
+
+// Serializer encrypts fields tagged with `gorm:"serializer:pii"`. Fields
+// have to be strings or byte slices such as json.RawMessage.
+type Serializer struct{}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("pii: unsupported column value %T for %s", dbValue, field.Name)
+	}
+
+	k, err := keyring()
+	if err != nil {
+		return err
+	}
+	value, err := k.Decrypt(stored)
+	if err != nil {
+		return fmt.Errorf("pii: decrypt %s: %w", field.Name, err)
+	}
+	if field.FieldType.Kind() == reflect.Slice && field.FieldType.Elem().Kind() == reflect.Uint8 {
+		if value == "" {
+			return field.Set(ctx, dst, reflect.Zero(field.FieldType).Interface())
+		}
+		return field.Set(ctx, dst, reflect.ValueOf([]byte(value)).Convert(field.FieldType).Interface())
+	}
+	return field.Set(ctx, dst, value)
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var value string
+	switch v := reflect.ValueOf(fieldValue); {
+	case v.Kind() == reflect.String:
+		value = v.String()
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		if v.IsNil() {
+			return nil, nil
+		}
+		value = string(v.Bytes())
+	default:
+		return nil, fmt.Errorf("pii: field %s must be a string or byte slice, got %T", field.Name, fieldValue)
+	}
+
+	k, err := keyring()
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(value)
+}
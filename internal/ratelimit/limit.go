@@ -0,0 +1,108 @@
+// Package ratelimit implements token bucket rate limiting for HTTP routes.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows bursts of up to Requests and refills the bucket completely
+// over Period. A zero Limit disables limiting.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses limits written as "<requests>/<period>", e.g. "10/1m".
+// "off" and the empty string yield a zero Limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must look like 10/1m", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid request count in rate limit %q", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid period in rate limit %q", s)
+	}
+
+	return Limit{Requests: n, Period: d}, nil
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// rate is the number of tokens added per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result describes the state of a bucket after a request was counted.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// ResetAfter is the time until the bucket is full again.
+	ResetAfter time.Duration
+	// RetryAfter is the time until the next request is allowed. It is zero
+	// when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// bucket is the stored state of a token bucket. Both stores share the same
+// arithmetic so they behave identically.
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+func newBucket(limit Limit, now time.Time) bucket {
+	return bucket{Tokens: float64(limit.Requests), UpdatedAt: now}
+}
+
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	rate := limit.rate()
+	capacity := float64(limit.Requests)
+
+	// Instances sharing a bucket may disagree slightly about the time; never
+	// move it backwards, or the same interval would be refilled twice.
+	if now.After(b.UpdatedAt) {
+		b.Tokens = min(capacity, b.Tokens+now.Sub(b.UpdatedAt).Seconds()*rate)
+		b.UpdatedAt = now
+	}
+
+	result := Result{Limit: limit}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.Tokens) / rate)
+	}
+
+	result.Remaining = int(b.Tokens)
+	result.ResetAfter = seconds((capacity - b.Tokens) / rate)
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+)
+
+// maxPeekBody caps how much of a request body ByEmail reads.
+const maxPeekBody = 64 << 10
+
+// KeyFunc extracts what a rule counts requests by. An empty key skips the rule.
+type KeyFunc func(c *gin.Context) string
+
+// Rule limits requests sharing the same key. Name separates the buckets of
+// different rules using the same kind of key.
+type Rule struct {
+	Name  string
+	Limit Limit
+	Key   KeyFunc
+}
+
+// ByIP counts requests per client IP.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUserID counts requests per authenticated user. It has to run after
+// AuthMiddleware.
+func ByUserID(c *gin.Context) string {
+	userID, ok := c.Get("userID")
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// ByEmail counts requests per email address, taken from the email query
+// parameter or the email field of a JSON body. Aliases of one mailbox share a
+// bucket. The body is restored so the handler can still bind it.
+func ByEmail(c *gin.Context) string {
+	email := c.Query("email")
+	if email == "" && c.Request.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPeekBody))
+		if err != nil {
+			return ""
+		}
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+
+		var payload struct {
+			Email string `json:"email"`
+		}
+		if json.Unmarshal(body, &payload) == nil {
+			email = payload.Email
+		}
+	}
+
+	email = emailpolicy.Normalize(email)
+	if email == "" {
+		return ""
+	}
+	return "email:" + email
+}
+
+// Middleware enforces rules in order and answers 429 as soon as one of them
+// is exceeded. The RateLimit-* headers describe the rule closest to its
+// limit. Store errors let the request through rather than take the API down.
+func Middleware(store Store, rules ...Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		var tightest *Result
+
+		for _, rule := range rules {
+			if !rule.Limit.Enabled() {
+				continue
+			}
+			key := rule.Key(c)
+			if key == "" {
+				continue
+			}
+
+			result, err := store.Take(rule.Name+":"+key, rule.Limit, now)
+			if err != nil {
+				log.Printf("rate limit %s: %v", rule.Name, err)
+				continue
+			}
+
+			if tightest == nil || !result.Allowed || result.Remaining < tightest.Remaining {
+				tightest = &result
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+
+		if tightest == nil {
+			c.Next()
+			return
+		}
+
+		setHeaders(c, *tightest)
+		if !tightest.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func setHeaders(c *gin.Context, result Result) {
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit.Requests, ceilSeconds(result.Limit.Period)))
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit.Requests))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
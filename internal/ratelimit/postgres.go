@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so that all
+// instances share the same limits.
+type PostgresStore struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+	// retention is how long an untouched bucket is kept. It should exceed
+	// the longest configured period.
+	retention time.Duration
+}
+
+func NewPostgresStore(db *gorm.DB, retention time.Duration) *PostgresStore {
+	return &PostgresStore{db: db, retention: retention}
+}
+
+func (s *PostgresStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.maybeSweep(now)
+
+	var result Result
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		initial := newBucket(limit, now)
+		row := models.RateLimitBucket{Key: key, Tokens: initial.Tokens, UpdatedAt: initial.UpdatedAt}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).Take(&row).Error; err != nil {
+			return err
+		}
+
+		b := bucket{Tokens: row.Tokens, UpdatedAt: row.UpdatedAt}
+		result = b.take(limit, now)
+
+		return tx.Model(&models.RateLimitBucket{}).Where("key = ?", key).Updates(map[string]any{
+			"tokens":     b.Tokens,
+			"updated_at": b.UpdatedAt,
+		}).Error
+	})
+	return result, err
+}
+
+// maybeSweep deletes stale buckets in the background at most once a minute.
+func (s *PostgresStore) maybeSweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	cutoff := now.Add(-s.retention)
+	go func() {
+		if err := s.db.Where("updated_at < ?", cutoff).Delete(&models.RateLimitBucket{}).Error; err != nil {
+			log.Printf("rate limit sweep: %v", err)
+		}
+	}()
+}
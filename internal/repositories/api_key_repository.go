@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository interface {
+	Create(key *models.APIKey) error
+	GetByPrefix(prefix string) (*models.APIKey, error)
+	GetForUser(userID, id uint) (*models.APIKey, error)
+	ListForUser(userID uint) ([]models.APIKey, error)
+	Revoke(id uint, now time.Time) error
+	RevokeAllForUser(userID uint, now time.Time) error
+	TouchLastUsed(id uint, now time.Time) error
+}
+
+type apiKeyRepo struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &apiKeyRepo{db}
+}
+
+func (r *apiKeyRepo) Create(key *models.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *apiKeyRepo) GetByPrefix(prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.Where("prefix = ?", prefix).Take(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *apiKeyRepo) GetForUser(userID, id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).Take(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *apiKeyRepo) ListForUser(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (r *apiKeyRepo) Revoke(id uint, now time.Time) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", now).Error
+}
+
+func (r *apiKeyRepo) RevokeAllForUser(userID uint, now time.Time) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
+
+// TouchLastUsed records a use of the key. Writes are skipped while the stored
+// time is less than a minute old, so busy keys do not cause a write per request.
+func (r *apiKeyRepo) TouchLastUsed(id uint, now time.Time) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-time.Minute)).
+		UpdateColumn("last_used_at", now).Error
+}
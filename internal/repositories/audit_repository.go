@@ -0,0 +1,163 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/pii"
+	"gorm.io/gorm"
+)
+
+// auditLockKey identifies the advisory lock serializing appends, so that two
+// entries never chain onto the same predecessor.
+const auditLockKey = 7316041
+
+type AuditFilter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	IP         string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+// AuditRepository only appends and reads; entries are never deleted, and the
+// only update is the redaction of their personal data.
+type AuditRepository interface {
+	Append(entry *models.AuditEntry) error
+	RedactForUser(userID uint, at time.Time) (int64, error)
+	List(filter AuditFilter) ([]models.AuditEntry, int64, error)
+	ListForUser(userID uint) ([]models.AuditEntry, error)
+	Stream(batchSize int, fn func(entry *models.AuditEntry) error) error
+}
+
+type auditRepo struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &auditRepo{db}
+}
+
+// Append links the entry to the latest one and stores it.
+func (r *auditRepo) Append(entry *models.AuditEntry) error {
+	if entry.IP != "" {
+		index, err := pii.BlindIndex(entry.IP)
+		if err != nil {
+			return err
+		}
+		entry.IPIndex = index
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditLockKey).Error; err != nil {
+			return err
+		}
+
+		var last models.AuditEntry
+		err := tx.Order("id DESC").Take(&last).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			entry.PrevHash = ""
+		case err != nil:
+			return err
+		default:
+			entry.PrevHash = last.Hash
+		}
+
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		if err := entry.SealDetails(); err != nil {
+			return err
+		}
+		entry.Hash = entry.ComputeHash()
+		return tx.Create(entry).Error
+	})
+}
+
+// RedactForUser clears the IP, user agent and before/after values of the
+// entries the user caused or that are about them. Entries written before
+// details were hashed separately are left as they are, since clearing them
+// would break the chain.
+func (r *auditRepo) RedactForUser(userID uint, at time.Time) (int64, error) {
+	result := r.db.Model(&models.AuditEntry{}).
+		Where("actor_id = ? OR (target_type = ? AND target_id = ?)", userID, models.AuditTargetUser, userID).
+		Where("details_hash <> '' AND redacted_at IS NULL").
+		UpdateColumns(map[string]interface{}{
+			"ip":           "",
+			"ip_index":     nil,
+			"user_agent":   "",
+			"before":       nil,
+			"after":        nil,
+			"details_salt": "",
+			"redacted_at":  at,
+		})
+	return result.RowsAffected, result.Error
+}
+
+func (r *auditRepo) List(filter AuditFilter) ([]models.AuditEntry, int64, error) {
+	query := r.db.Model(&models.AuditEntry{})
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.IP != "" {
+		// IPs are stored encrypted; entries written before they were indexed
+		// still match on the plaintext column until rotate-pii-keys has run.
+		index, err := pii.BlindIndex(filter.IP)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where("ip_index = ? OR (ip_index IS NULL AND ip = ?)", index, filter.IP)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []models.AuditEntry
+	if err := query.Order("id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// ListForUser returns the entries the user caused or that are about them.
+func (r *auditRepo) ListForUser(userID uint) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	err := r.db.Where("actor_id = ? OR (target_type = ? AND target_id = ?)", userID, models.AuditTargetUser, userID).
+		Order("id").
+		Find(&entries).Error
+	return entries, err
+}
+
+// Stream walks the whole log in insertion order.
+func (r *auditRepo) Stream(batchSize int, fn func(entry *models.AuditEntry) error) error {
+	var entries []models.AuditEntry
+	return r.db.Order("id").FindInBatches(&entries, batchSize, func(tx *gorm.DB, batch int) error {
+		for i := range entries {
+			if err := fn(&entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
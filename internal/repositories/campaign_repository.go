@@ -0,0 +1,173 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrCodeBatchLeaseLost is returned when a code batch is updated by an
+// instance whose lease on it has expired or was taken over.
+var ErrCodeBatchLeaseLost = errors.New("lease on the code batch was lost")
+
+type CampaignRepository interface {
+	Create(campaign *models.Campaign) error
+	GetByID(id uint) (*models.Campaign, error)
+	List() ([]models.Campaign, error)
+	Update(campaign *models.Campaign) error
+	Delete(id uint) error
+	CountReferrals(campaignID uint) (int64, error)
+	GetStats(campaignID uint) (*models.CampaignStats, error)
+	CreateCodeBatch(batch *models.CodeBatch) error
+	GetCodeBatch(id uint) (*models.CodeBatch, error)
+	UpdateCodeBatch(batch *models.CodeBatch, owner string, now time.Time) error
+	GetUnfinishedCodeBatches() ([]models.CodeBatch, error)
+	ClaimCodeBatch(id uint, owner string, now, until time.Time) (bool, error)
+}
+
+type campaignRepo struct {
+	db *gorm.DB
+}
+
+func NewCampaignRepository(db *gorm.DB) CampaignRepository {
+	return &campaignRepo{db}
+}
+
+func (r *campaignRepo) Create(campaign *models.Campaign) error {
+	return r.db.Create(campaign).Error
+}
+
+func (r *campaignRepo) GetByID(id uint) (*models.Campaign, error) {
+	var campaign models.Campaign
+	if err := r.db.First(&campaign, id).Error; err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+func (r *campaignRepo) List() ([]models.Campaign, error) {
+	var campaigns []models.Campaign
+	if err := r.db.Order("id DESC").Find(&campaigns).Error; err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+func (r *campaignRepo) Update(campaign *models.Campaign) error {
+	return r.db.Save(campaign).Error
+}
+
+func (r *campaignRepo) Delete(id uint) error {
+	return r.db.Delete(&models.Campaign{}, id).Error
+}
+
+func (r *campaignRepo) CountReferrals(campaignID uint) (int64, error) {
+	var count int64
+	if err := r.db.Model(&models.Referral{}).Where("campaign_id = ?", campaignID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *campaignRepo) GetStats(campaignID uint) (*models.CampaignStats, error) {
+	stats := &models.CampaignStats{CampaignID: campaignID}
+
+	referrals := func() *gorm.DB {
+		return r.db.Model(&models.Referral{}).Where("campaign_id = ?", campaignID)
+	}
+
+	if err := referrals().Count(&stats.Referrals).Error; err != nil {
+		return nil, err
+	}
+
+	if err := referrals().Distinct("referred_by").Count(&stats.UniqueReferrers).Error; err != nil {
+		return nil, err
+	}
+
+	var promoCodes, personalCodes int64
+	if err := r.db.Model(&models.PromoCode{}).Where("campaign_id = ?", campaignID).Count(&promoCodes).Error; err != nil {
+		return nil, err
+	}
+	if err := r.db.Model(&models.User{}).Where("referral_campaign_id = ? AND referral_code <> ''", campaignID).Count(&personalCodes).Error; err != nil {
+		return nil, err
+	}
+	stats.CodesIssued = promoCodes + personalCodes
+
+	if err := referrals().Distinct("referral_code").Count(&stats.CodesRedeemed).Error; err != nil {
+		return nil, err
+	}
+
+	err := r.db.Model(&models.Reward{}).
+		Where("campaign_id = ? AND status <> ?", campaignID, models.RewardStatusVoid).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&stats.RewardsTotal).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = referrals().
+		Select("TO_CHAR(DATE(created_at), 'YYYY-MM-DD') AS date, COUNT(*) AS count").
+		Group("DATE(created_at)").
+		Order("DATE(created_at)").
+		Scan(&stats.DailyReferrals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (r *campaignRepo) CreateCodeBatch(batch *models.CodeBatch) error {
+	return r.db.Create(batch).Error
+}
+
+func (r *campaignRepo) GetCodeBatch(id uint) (*models.CodeBatch, error) {
+	var batch models.CodeBatch
+	if err := r.db.First(&batch, id).Error; err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// UpdateCodeBatch saves the batch as long as owner still holds an unexpired
+// lease on it, and returns ErrCodeBatchLeaseLost otherwise.
+func (r *campaignRepo) UpdateCodeBatch(batch *models.CodeBatch, owner string, now time.Time) error {
+	result := r.db.Model(batch).
+		Where("lease_owner = ? AND lease_until > ?", owner, now).
+		Select("*").Omit("created_at").
+		Updates(batch)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrCodeBatchLeaseLost
+	}
+	return nil
+}
+
+// ClaimCodeBatch leases an unfinished batch to owner until the given time,
+// unless another instance holds an unexpired lease on it. It reports whether
+// the claim succeeded.
+func (r *campaignRepo) ClaimCodeBatch(id uint, owner string, now, until time.Time) (bool, error) {
+	result := r.db.Model(&models.CodeBatch{}).
+		Where("id = ? AND status IN ?", id, []string{models.CodeBatchStatusPending, models.CodeBatchStatusRunning}).
+		Where("lease_until IS NULL OR lease_until < ?", now).
+		Updates(map[string]interface{}{"lease_owner": owner, "lease_until": until})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *campaignRepo) GetUnfinishedCodeBatches() ([]models.CodeBatch, error) {
+	var batches []models.CodeBatch
+	err := r.db.Where("status IN ?", []string{models.CodeBatchStatusPending, models.CodeBatchStatusRunning}).
+		Order("id").
+		Find(&batches).Error
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
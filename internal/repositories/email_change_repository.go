@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type EmailChangeRepository interface {
+	Create(change *models.EmailChange) error
+	GetPending(userID uint, now time.Time) (*models.EmailChange, error)
+	Consume(tokenHash string) (*models.EmailChange, error)
+	DeleteForUser(userID uint) error
+}
+
+type emailChangeRepo struct {
+	db *gorm.DB
+}
+
+func NewEmailChangeRepository(db *gorm.DB) EmailChangeRepository {
+	return &emailChangeRepo{db}
+}
+
+// Create stores a new request, replacing any earlier one of the same user,
+// and clears out expired requests.
+func (r *emailChangeRepo) Create(change *models.EmailChange) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? OR expires_at < ?", change.UserID, time.Now()).Delete(&models.EmailChange{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(change).Error
+	})
+}
+
+func (r *emailChangeRepo) GetPending(userID uint, now time.Time) (*models.EmailChange, error) {
+	var change models.EmailChange
+	if err := r.db.Where("user_id = ? AND expires_at > ?", userID, now).Take(&change).Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// Consume deletes and returns a request, so a confirmation link works once.
+func (r *emailChangeRepo) Consume(tokenHash string) (*models.EmailChange, error) {
+	var consumed []models.EmailChange
+	if err := r.db.Clauses(clause.Returning{}).Where("token_hash = ?", tokenHash).Delete(&consumed).Error; err != nil {
+		return nil, err
+	}
+	if len(consumed) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &consumed[0], nil
+}
+
+func (r *emailChangeRepo) DeleteForUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.EmailChange{}).Error
+}
@@ -0,0 +1,39 @@
+package repositories
+
+import (
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+	"github.com/serlenario/referral-system/internal/pii"
+)
+
+// byEmail matches users by the blind index of their normalised address, since
+// the address itself is stored encrypted. Rows indexed before addresses were
+// normalised are matched on the index of the address as given, and rows
+// written before the index existed on the plaintext column, until
+// rotate-pii-keys has reindexed them.
+func byEmail(emails ...string) (string, []interface{}, error) {
+	indexes := make([]string, 0, 2*len(emails))
+	for _, email := range emails {
+		for _, value := range []string{emailpolicy.Normalize(email), email} {
+			index, err := pii.BlindIndex(value)
+			if err != nil {
+				return "", nil, err
+			}
+			indexes = append(indexes, index)
+		}
+	}
+	return "email_index IN ? OR (email_index IS NULL AND email IN ?)", []interface{}{indexes, emails}, nil
+}
+
+// chunkStrings splits values so IN clauses stay well below the driver's
+// parameter limit.
+func chunkStrings(values []string, size int) [][]string {
+	var chunks [][]string
+	for size < len(values) {
+		chunks = append(chunks, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+	return chunks
+}
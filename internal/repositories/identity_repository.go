@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IdentityRepository interface {
+	Get(provider, subject string) (*models.UserIdentity, error)
+	Create(identity *models.UserIdentity) error
+	ListForUser(userID uint) ([]models.UserIdentity, error)
+	DeleteForUser(userID uint) error
+	CreateState(state *models.OAuthState) error
+	ConsumeState(state string) (*models.OAuthState, error)
+}
+
+type identityRepo struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &identityRepo{db}
+}
+
+func (r *identityRepo) Get(provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := r.db.Where("provider = ? AND subject = ?", provider, subject).Take(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *identityRepo) Create(identity *models.UserIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *identityRepo) ListForUser(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+func (r *identityRepo) DeleteForUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.UserIdentity{}).Error
+}
+
+// CreateState stores a new login state and clears out abandoned ones.
+func (r *identityRepo) CreateState(state *models.OAuthState) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&models.OAuthState{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(state).Error
+}
+
+// ConsumeState deletes and returns a login state, so it can be used only once
+// even if the callback is replayed concurrently.
+func (r *identityRepo) ConsumeState(state string) (*models.OAuthState, error) {
+	var consumed []models.OAuthState
+	if err := r.db.Clauses(clause.Returning{}).Where("state = ?", state).Delete(&consumed).Error; err != nil {
+		return nil, err
+	}
+	if len(consumed) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &consumed[0], nil
+}
@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/pii"
+	"gorm.io/gorm"
+)
+
+// LoginThrottleRepository stores subjects, which are email addresses and IPs,
+// as their blind index, so the table holds no personal data.
+type LoginThrottleRepository interface {
+	Get(scope, subject string) (*models.LoginThrottle, error)
+	RecordFailure(scope, subject string, now, windowStart time.Time) (*models.LoginThrottle, error)
+	Block(id uint, until time.Time) error
+	Reset(scope, subject string) error
+}
+
+type loginThrottleRepo struct {
+	db *gorm.DB
+}
+
+func NewLoginThrottleRepository(db *gorm.DB) LoginThrottleRepository {
+	return &loginThrottleRepo{db}
+}
+
+func (r *loginThrottleRepo) Get(scope, subject string) (*models.LoginThrottle, error) {
+	index, err := pii.BlindIndex(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var throttle models.LoginThrottle
+	if err := r.db.Where("scope = ? AND subject = ?", scope, index).Take(&throttle).Error; err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// RecordFailure counts a failed attempt in a single statement so concurrent
+// guesses cannot overwrite each other. Failures older than windowStart are
+// forgotten and counting starts over.
+func (r *loginThrottleRepo) RecordFailure(scope, subject string, now, windowStart time.Time) (*models.LoginThrottle, error) {
+	index, err := pii.BlindIndex(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var throttle models.LoginThrottle
+	err = r.db.Raw(`
+		INSERT INTO login_throttles (scope, subject, failures, last_failure_at, created_at, updated_at)
+		VALUES (?, ?, 1, ?, ?, ?)
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING *`,
+		scope, index, now, now, now, windowStart,
+	).Scan(&throttle).Error
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (r *loginThrottleRepo) Block(id uint, until time.Time) error {
+	return r.db.Model(&models.LoginThrottle{}).Where("id = ?", id).Update("blocked_until", until).Error
+}
+
+func (r *loginThrottleRepo) Reset(scope, subject string) error {
+	index, err := pii.BlindIndex(subject)
+	if err != nil {
+		return err
+	}
+	return r.db.Where("scope = ? AND subject = ?", scope, index).Delete(&models.LoginThrottle{}).Error
+}
@@ -0,0 +1,46 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type MagicLinkRepository interface {
+	Create(link *models.MagicLink) error
+	Consume(jti string, now time.Time) (bool, error)
+	DeleteForUser(userID uint) error
+}
+
+type magicLinkRepo struct {
+	db *gorm.DB
+}
+
+func NewMagicLinkRepository(db *gorm.DB) MagicLinkRepository {
+	return &magicLinkRepo{db}
+}
+
+// Create stores a new link and removes expired ones.
+func (r *magicLinkRepo) Create(link *models.MagicLink) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&models.MagicLink{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(link).Error
+}
+
+// Consume marks a link as used. It reports false if the link is unknown,
+// expired or was already used, including by a concurrent request.
+func (r *magicLinkRepo) Consume(jti string, now time.Time) (bool, error) {
+	result := r.db.Model(&models.MagicLink{}).
+		Where("jti = ? AND used_at IS NULL AND expires_at > ?", jti, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *magicLinkRepo) DeleteForUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.MagicLink{}).Error
+}
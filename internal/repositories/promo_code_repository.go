@@ -0,0 +1,98 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PromoCodeRepository interface {
+	// CreateIgnoringDuplicates inserts the codes, skipping any whose code already
+	// exists, and returns how many rows were actually inserted.
+	CreateIgnoringDuplicates(codes []models.PromoCode) (int64, error)
+	CreateAll(codes []models.PromoCode) error
+	GetByCode(code string) (*models.PromoCode, error)
+	FindExistingCodes(codes []string) ([]string, error)
+	ListByOwner(ownerID uint) ([]models.PromoCode, error)
+	RevokeByOwner(ownerID uint) (int64, error)
+	CountByBatch(batchID uint) (int64, error)
+	StreamByBatch(batchID uint, batchSize int, fn func(code *models.PromoCode) error) error
+}
+
+type promoCodeRepo struct {
+	db *gorm.DB
+}
+
+func NewPromoCodeRepository(db *gorm.DB) PromoCodeRepository {
+	return &promoCodeRepo{db}
+}
+
+func (r *promoCodeRepo) CreateIgnoringDuplicates(codes []models.PromoCode) (int64, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&codes)
+	return result.RowsAffected, result.Error
+}
+
+// CreateAll inserts all codes in a single transaction, so either every code
+// is stored or none is.
+func (r *promoCodeRepo) CreateAll(codes []models.PromoCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(&codes, 500).Error
+	})
+}
+
+func (r *promoCodeRepo) FindExistingCodes(codes []string) ([]string, error) {
+	var existing []string
+	for _, chunk := range chunkStrings(codes, 1000) {
+		var found []string
+		if err := r.db.Model(&models.PromoCode{}).Where("code IN ?", chunk).Pluck("code", &found).Error; err != nil {
+			return nil, err
+		}
+		existing = append(existing, found...)
+	}
+	return existing, nil
+}
+
+func (r *promoCodeRepo) GetByCode(code string) (*models.PromoCode, error) {
+	var promoCode models.PromoCode
+	if err := r.db.Where("code = ?", code).First(&promoCode).Error; err != nil {
+		return nil, err
+	}
+	return &promoCode, nil
+}
+
+func (r *promoCodeRepo) ListByOwner(ownerID uint) ([]models.PromoCode, error) {
+	var codes []models.PromoCode
+	if err := r.db.Where("owner_id = ?", ownerID).Order("id").Find(&codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (r *promoCodeRepo) RevokeByOwner(ownerID uint) (int64, error) {
+	result := r.db.Model(&models.PromoCode{}).
+		Where("owner_id = ? AND revoked_at IS NULL", ownerID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+func (r *promoCodeRepo) CountByBatch(batchID uint) (int64, error) {
+	var count int64
+	if err := r.db.Model(&models.PromoCode{}).Where("batch_id = ?", batchID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *promoCodeRepo) StreamByBatch(batchID uint, batchSize int, fn func(code *models.PromoCode) error) error {
+	var codes []models.PromoCode
+	return r.db.Where("batch_id = ?", batchID).FindInBatches(&codes, batchSize, func(tx *gorm.DB, batch int) error {
+		for i := range codes {
+			if err := fn(&codes[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
@@ -1,13 +1,42 @@
 package repositories
 
 import (
+	"errors"
+	"time"
+
 	"github.com/serlenario/referral-system/internal/models"
 	"gorm.io/gorm"
 )
 
+// ErrReferralNotPending is returned by Qualify for a referral that was
+// already reviewed.
+var ErrReferralNotPending = errors.New("referral is not pending")
+
+type ReferralFilter struct {
+	ReferrerID uint
+	ReferredID uint
+	From       time.Time
+	To         time.Time
+	Status     string
+	Code       string
+	CampaignID uint
+	Ascending  bool
+
+	// AfterCreatedAt and AfterID identify the last row of the previous page.
+	AfterCreatedAt time.Time
+	AfterID        uint
+
+	Limit int
+}
+
 type ReferralRepository interface {
 	Create(referral *models.Referral) error
-	GetByReferrerID(referrerID uint) ([]models.Referral, error)
+	GetByID(id uint) (*models.Referral, error)
+	Qualify(referral *models.Referral, rewards []*models.Reward) error
+	List(filter ReferralFilter) ([]models.Referral, error)
+	Count(filter ReferralFilter) (int64, error)
+	Stream(filter ReferralFilter, batchSize int, fn func(referral *models.Referral) error) error
+	GetTopReferrers(since time.Time, limit, offset int) ([]models.ReferrerStats, int64, error)
 }
 
 type referralRepo struct {
@@ -22,10 +51,154 @@ func (r *referralRepo) Create(referral *models.Referral) error {
 	return r.db.Create(referral).Error
 }
 
-func (r *referralRepo) GetByReferrerID(referrerID uint) ([]models.Referral, error) {
+func (r *referralRepo) GetByID(id uint) (*models.Referral, error) {
+	var referral models.Referral
+	if err := r.db.First(&referral, id).Error; err != nil {
+		return nil, err
+	}
+	return &referral, nil
+}
+
+// Qualify marks a pending referral as qualified and stores its rewards in one
+// transaction. Only one of two concurrent calls succeeds; the other gets
+// ErrReferralNotPending.
+func (r *referralRepo) Qualify(referral *models.Referral, rewards []*models.Reward) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Referral{}).
+			Where("id = ? AND status = ?", referral.ID, models.ReferralStatusPending).
+			Update("status", models.ReferralStatusQualified)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrReferralNotPending
+		}
+		referral.Status = models.ReferralStatusQualified
+
+		for _, reward := range rewards {
+			if err := tx.Create(reward).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *referralRepo) List(filter ReferralFilter) ([]models.Referral, error) {
+	query := r.filtered(filter)
+
+	if filter.AfterID != 0 {
+		if filter.Ascending {
+			query = query.Where("(referrals.created_at, referrals.id) > (?, ?)", filter.AfterCreatedAt, filter.AfterID)
+		} else {
+			query = query.Where("(referrals.created_at, referrals.id) < (?, ?)", filter.AfterCreatedAt, filter.AfterID)
+		}
+	}
+
+	if filter.Ascending {
+		query = query.Order("referrals.created_at ASC, referrals.id ASC")
+	} else {
+		query = query.Order("referrals.created_at DESC, referrals.id DESC")
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
 	var referrals []models.Referral
-	if err := r.db.Where("referred_by = ?", referrerID).Find(&referrals).Error; err != nil {
+	if err := query.Joins("Referred").Find(&referrals).Error; err != nil {
 		return nil, err
 	}
 	return referrals, nil
 }
+
+func (r *referralRepo) Count(filter ReferralFilter) (int64, error) {
+	var total int64
+	if err := r.filtered(filter).Count(&total).Error; err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// Stream walks every referral matching the filter in keyset-paginated batches,
+// so callers never hold more than batchSize rows in memory.
+func (r *referralRepo) Stream(filter ReferralFilter, batchSize int, fn func(referral *models.Referral) error) error {
+	filter.Limit = batchSize
+	for {
+		referrals, err := r.List(filter)
+		if err != nil {
+			return err
+		}
+
+		for i := range referrals {
+			if err := fn(&referrals[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(referrals) < batchSize {
+			return nil
+		}
+		last := referrals[len(referrals)-1]
+		filter.AfterCreatedAt = last.CreatedAt
+		filter.AfterID = last.ID
+	}
+}
+
+func (r *referralRepo) filtered(filter ReferralFilter) *gorm.DB {
+	query := r.db.Model(&models.Referral{})
+	if filter.ReferrerID != 0 {
+		query = query.Where("referrals.referred_by = ?", filter.ReferrerID)
+	}
+	if filter.ReferredID != 0 {
+		query = query.Where("referrals.referred_id = ?", filter.ReferredID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("referrals.created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("referrals.created_at < ?", filter.To)
+	}
+	if filter.Status != "" {
+		query = query.Where("referrals.status = ?", filter.Status)
+	}
+	if filter.Code != "" {
+		query = query.Where("referrals.referral_code = ?", filter.Code)
+	}
+	if filter.CampaignID != 0 {
+		query = query.Where("referrals.campaign_id = ?", filter.CampaignID)
+	}
+	return query
+}
+
+func (r *referralRepo) GetTopReferrers(since time.Time, limit, offset int) ([]models.ReferrerStats, int64, error) {
+	var total int64
+	if err := r.topReferrersQuery(since).Distinct("referrals.referred_by").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var stats []models.ReferrerStats
+	err := r.topReferrersQuery(since).
+		Select("users.id AS user_id, users.email, users.display_name, COUNT(referrals.id) AS referral_count").
+		Group("users.id, users.email, users.display_name").
+		Order("referral_count DESC, MAX(referrals.created_at) ASC, users.id ASC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return stats, total, nil
+}
+
+func (r *referralRepo) topReferrersQuery(since time.Time) *gorm.DB {
+	query := r.db.Table("referrals").
+		Joins("JOIN users ON users.id = referrals.referred_by AND users.deleted_at IS NULL").
+		Where("referrals.deleted_at IS NULL").
+		Where("referrals.status = ?", models.ReferralStatusQualified).
+		Where("users.leaderboard_opt_out = ?", false)
+	if !since.IsZero() {
+		query = query.Where("referrals.created_at >= ?", since)
+	}
+	return query
+}
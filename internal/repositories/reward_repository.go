@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type RewardRepository interface {
+	Create(reward *models.Reward) error
+	ListForUser(userID uint) ([]models.Reward, error)
+	GrantPending(userID uint) (int64, error)
+	Stream(batchSize int, fn func(reward *models.Reward) error) error
+}
+
+type rewardRepo struct {
+	db *gorm.DB
+}
+
+func NewRewardRepository(db *gorm.DB) RewardRepository {
+	return &rewardRepo{db}
+}
+
+func (r *rewardRepo) Create(reward *models.Reward) error {
+	return r.db.Create(reward).Error
+}
+
+func (r *rewardRepo) ListForUser(userID uint) ([]models.Reward, error) {
+	var rewards []models.Reward
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&rewards).Error
+	return rewards, err
+}
+
+// GrantPending grants the user's rewards that were held back and returns how
+// many there were.
+func (r *rewardRepo) GrantPending(userID uint) (int64, error) {
+	result := r.db.Model(&models.Reward{}).
+		Where("user_id = ? AND status = ?", userID, models.RewardStatusPending).
+		Update("status", models.RewardStatusGranted)
+	return result.RowsAffected, result.Error
+}
+
+func (r *rewardRepo) Stream(batchSize int, fn func(reward *models.Reward) error) error {
+	var rewards []models.Reward
+	return r.db.FindInBatches(&rewards, batchSize, func(tx *gorm.DB, batch int) error {
+		for i := range rewards {
+			if err := fn(&rewards[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
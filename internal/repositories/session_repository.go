@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+)
+
+type SessionRepository interface {
+	Create(session *models.Session) error
+	GetByID(id uint) (*models.Session, error)
+	ListActive(userID uint, now time.Time) ([]models.Session, error)
+	Revoke(id uint, now time.Time) error
+	RevokeOthers(userID, keepID uint, now time.Time) error
+	RedactForUser(userID uint) error
+	TouchLastSeen(id uint, now time.Time) error
+}
+
+type sessionRepo struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepo{db}
+}
+
+// Create stores a new session and removes ones that have expired.
+func (r *sessionRepo) Create(session *models.Session) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&models.Session{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(session).Error
+}
+
+func (r *sessionRepo) GetByID(id uint) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepo) ListActive(userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *sessionRepo) Revoke(id uint, now time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", now).Error
+}
+
+// RevokeOthers ends all sessions of the user except keepID.
+func (r *sessionRepo) RevokeOthers(userID, keepID uint, now time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL AND expires_at > ?", userID, keepID, now).
+		Update("revoked_at", now).Error
+}
+
+// RedactForUser clears the IP address, user agent and device of every
+// session of the user.
+func (r *sessionRepo) RedactForUser(userID uint) error {
+	return r.db.Model(&models.Session{}).
+		Where("user_id = ?", userID).
+		UpdateColumns(map[string]interface{}{"ip": "", "user_agent": "", "device": ""}).Error
+}
+
+// TouchLastSeen records activity on the session, at most once a minute.
+func (r *sessionRepo) TouchLastSeen(id uint, now time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ? AND last_seen_at < ?", id, now.Add(-time.Minute)).
+		UpdateColumn("last_seen_at", now).Error
+}
@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"github.com/serlenario/referral-system/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TermsRepository interface {
+	CreateVersion(version *models.TermsVersion) error
+	GetVersion(version string) (*models.TermsVersion, error)
+	GetCurrent() (*models.TermsVersion, error)
+	ListVersions() ([]models.TermsVersion, error)
+	CreateAcceptance(acceptance *models.TermsAcceptance) error
+	GetAcceptance(userID uint, version string) (*models.TermsAcceptance, error)
+	GetLatestAcceptance(userID uint) (*models.TermsAcceptance, error)
+	ListAcceptancesForUser(userID uint) ([]models.TermsAcceptance, error)
+	RedactAcceptances(userID uint) error
+}
+
+type termsRepo struct {
+	db *gorm.DB
+}
+
+func NewTermsRepository(db *gorm.DB) TermsRepository {
+	return &termsRepo{db}
+}
+
+func (r *termsRepo) CreateVersion(version *models.TermsVersion) error {
+	return r.db.Create(version).Error
+}
+
+func (r *termsRepo) GetVersion(version string) (*models.TermsVersion, error) {
+	var terms models.TermsVersion
+	if err := r.db.Where("version = ?", version).Take(&terms).Error; err != nil {
+		return nil, err
+	}
+	return &terms, nil
+}
+
+// GetCurrent returns the most recently published version.
+func (r *termsRepo) GetCurrent() (*models.TermsVersion, error) {
+	var version models.TermsVersion
+	if err := r.db.Order("id DESC").Take(&version).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+func (r *termsRepo) ListVersions() ([]models.TermsVersion, error) {
+	var versions []models.TermsVersion
+	err := r.db.Order("id DESC").Find(&versions).Error
+	return versions, err
+}
+
+// CreateAcceptance stores an acceptance unless the user already accepted the
+// same version, in which case the first acceptance is kept.
+func (r *termsRepo) CreateAcceptance(acceptance *models.TermsAcceptance) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(acceptance).Error
+}
+
+func (r *termsRepo) GetAcceptance(userID uint, version string) (*models.TermsAcceptance, error) {
+	var acceptance models.TermsAcceptance
+	if err := r.db.Where("user_id = ? AND version = ?", userID, version).Take(&acceptance).Error; err != nil {
+		return nil, err
+	}
+	return &acceptance, nil
+}
+
+func (r *termsRepo) GetLatestAcceptance(userID uint) (*models.TermsAcceptance, error) {
+	var acceptance models.TermsAcceptance
+	if err := r.db.Where("user_id = ?", userID).Order("accepted_at DESC").Take(&acceptance).Error; err != nil {
+		return nil, err
+	}
+	return &acceptance, nil
+}
+
+func (r *termsRepo) ListAcceptancesForUser(userID uint) ([]models.TermsAcceptance, error) {
+	var acceptances []models.TermsAcceptance
+	err := r.db.Where("user_id = ?", userID).Order("accepted_at").Find(&acceptances).Error
+	return acceptances, err
+}
+
+// RedactAcceptances clears the IP address and user agent of the user's
+// acceptances. Which version they accepted and when is kept.
+func (r *termsRepo) RedactAcceptances(userID uint) error {
+	return r.db.Model(&models.TermsAcceptance{}).
+		Where("user_id = ?", userID).
+		UpdateColumns(map[string]interface{}{"ip": "", "user_agent": ""}).Error
+}
@@ -1,16 +1,60 @@
 package repositories
 
 import (
+	"errors"
+	"time"
+
 	"github.com/serlenario/referral-system/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCampaignFull         = errors.New("campaign has reached its participant limit")
+	ErrPromoCodeUnavailable = errors.New("promo code has no uses left")
 )
 
+type UserFilter struct {
+	Email  string
+	Role   string
+	Status string
+	Limit  int
+	Offset int
+}
+
+// Signup is a new account with the terms accepted and the referral that
+// brought it in, if any.
+type Signup struct {
+	User       *models.User
+	Acceptance *models.TermsAcceptance
+	Referral   *models.Referral
+	// PromoCodeID is the promo code redeemed by the referral.
+	PromoCodeID *uint
+	// MaxParticipants limits the referrals of the referral's campaign; zero
+	// means no limit.
+	MaxParticipants int
+	// Rewards of the referral. Referee rewards go to the new user.
+	Rewards []*models.Reward
+}
+
 type UserRepository interface {
 	Create(user *models.User) error
+	CreateSignup(signup *Signup) error
 	GetByEmail(email string) (*models.User, error)
 	GetByID(id uint) (*models.User, error)
 	GetByReferralCode(code string) (*models.User, error)
+	GetByEmails(emails []string) ([]models.User, error)
+	FindExistingReferralCodes(codes []string) ([]string, error)
 	Update(user *models.User) error
+	UpdatePasswordHash(userID uint, oldHash, newHash string) (bool, error)
+	MarkEmailVerified(userID uint, at time.Time) error
+	Reclaim(userID uint, at time.Time) error
+	Search(filter UserFilter) ([]models.User, int64, error)
+	CreateStatusChange(change *models.UserStatusChange) error
+	ChangeStatus(user *models.User, change *models.UserStatusChange, revokePromoCodes bool) (int64, error)
+	GetStatusChanges(userID uint) ([]models.UserStatusChange, error)
+	Stream(batchSize int, fn func(user *models.User) error) error
+	ListDueForDeletion(now time.Time, limit int) ([]models.User, error)
 }
 
 type userRepo struct {
@@ -25,9 +69,77 @@ func (r *userRepo) Create(user *models.User) error {
 	return r.db.Create(user).Error
 }
 
+// CreateSignup stores a new account with its terms acceptance, referral and
+// rewards in one transaction. The campaign row is locked while its participants are counted,
+// so concurrent signups cannot exceed the limit.
+func (r *userRepo) CreateSignup(signup *Signup) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		referral := signup.Referral
+		if referral != nil && referral.CampaignID != nil && signup.MaxParticipants > 0 {
+			var campaign models.Campaign
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Take(&campaign, *referral.CampaignID).Error; err != nil {
+				return err
+			}
+
+			var participants int64
+			if err := tx.Model(&models.Referral{}).Where("campaign_id = ?", campaign.ID).Count(&participants).Error; err != nil {
+				return err
+			}
+			if participants >= int64(signup.MaxParticipants) {
+				return ErrCampaignFull
+			}
+		}
+
+		if signup.PromoCodeID != nil {
+			result := tx.Model(&models.PromoCode{}).
+				Where("id = ? AND used_count < max_uses AND revoked_at IS NULL", *signup.PromoCodeID).
+				UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != 1 {
+				return ErrPromoCodeUnavailable
+			}
+		}
+
+		if err := tx.Create(signup.User).Error; err != nil {
+			return err
+		}
+		if signup.Acceptance != nil {
+			signup.Acceptance.UserID = signup.User.ID
+			if err := tx.Create(signup.Acceptance).Error; err != nil {
+				return err
+			}
+		}
+		if referral == nil {
+			return nil
+		}
+
+		referral.ReferredID = signup.User.ID
+		if err := tx.Create(referral).Error; err != nil {
+			return err
+		}
+		for _, reward := range signup.Rewards {
+			reward.ReferralID = referral.ID
+			if reward.Kind == models.RewardKindReferee {
+				reward.UserID = signup.User.ID
+			}
+			if err := tx.Create(reward).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (r *userRepo) GetByEmail(email string) (*models.User, error) {
+	query, args, err := byEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
 	var user models.User
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := r.db.Where(query, args...).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -49,6 +161,150 @@ func (r *userRepo) GetByReferralCode(code string) (*models.User, error) {
 	return &user, nil
 }
 
+func (r *userRepo) GetByEmails(emails []string) ([]models.User, error) {
+	var users []models.User
+	for _, chunk := range chunkStrings(emails, 1000) {
+		query, args, err := byEmail(chunk...)
+		if err != nil {
+			return nil, err
+		}
+
+		var found []models.User
+		if err := r.db.Where(query, args...).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		users = append(users, found...)
+	}
+	return users, nil
+}
+
+func (r *userRepo) FindExistingReferralCodes(codes []string) ([]string, error) {
+	var existing []string
+	for _, chunk := range chunkStrings(codes, 1000) {
+		var found []string
+		if err := r.db.Model(&models.User{}).Where("referral_code IN ?", chunk).Pluck("referral_code", &found).Error; err != nil {
+			return nil, err
+		}
+		existing = append(existing, found...)
+	}
+	return existing, nil
+}
+
 func (r *userRepo) Update(user *models.User) error {
 	return r.db.Save(user).Error
 }
+
+// UpdatePasswordHash replaces the password hash only while it is still
+// oldHash, so a password changed in the meantime is not overwritten. It
+// reports whether the hash was replaced.
+func (r *userRepo) UpdatePasswordHash(userID uint, oldHash, newHash string) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND password_hash = ?", userID, oldHash).
+		Update("password_hash", newHash)
+	return result.RowsAffected == 1, result.Error
+}
+
+// MarkEmailVerified records when the user's address was verified, unless it
+// already was.
+func (r *userRepo) MarkEmailVerified(userID uint, at time.Time) error {
+	return r.db.Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", at).Error
+}
+
+// Reclaim removes the password of the user and marks their address verified,
+// leaving the other columns as they are.
+func (r *userRepo) Reclaim(userID uint, at time.Time) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"password_hash": "", "email_verified_at": at}).Error
+}
+
+func (r *userRepo) Search(filter UserFilter) ([]models.User, int64, error) {
+	query := r.db.Model(&models.User{})
+	if filter.Email != "" {
+		condition, args, err := byEmail(filter.Email)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where(condition, args...)
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []models.User
+	if err := query.Order("id").Limit(filter.Limit).Offset(filter.Offset).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *userRepo) CreateStatusChange(change *models.UserStatusChange) error {
+	return r.db.Create(change).Error
+}
+
+// ChangeStatus saves a status change of the user together with its history
+// entry in one transaction, revoking the promo codes they own if asked to. It
+// returns the number of promo codes revoked.
+func (r *userRepo) ChangeStatus(user *models.User, change *models.UserStatusChange, revokePromoCodes bool) (int64, error) {
+	var revoked int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(change).Error; err != nil {
+			return err
+		}
+		if !revokePromoCodes {
+			return nil
+		}
+
+		result := tx.Model(&models.PromoCode{}).
+			Where("owner_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", time.Now())
+		revoked = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+func (r *userRepo) GetStatusChanges(userID uint) ([]models.UserStatusChange, error) {
+	var changes []models.UserStatusChange
+	if err := r.db.Where("user_id = ?", userID).Order("id DESC").Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (r *userRepo) Stream(batchSize int, fn func(user *models.User) error) error {
+	var users []models.User
+	return r.db.FindInBatches(&users, batchSize, func(tx *gorm.DB, batch int) error {
+		for i := range users {
+			if err := fn(&users[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// ListDueForDeletion returns accounts whose deletion grace period is over.
+func (r *userRepo) ListDueForDeletion(now time.Time, limit int) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("deletion_due_at <= ? AND status <> ?", now, models.UserStatusDeleted).
+		Order("deletion_due_at").
+		Limit(limit).
+		Find(&users).Error
+	return users, err
+}
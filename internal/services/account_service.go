@@ -0,0 +1,370 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/notify"
+	"github.com/serlenario/referral-system/internal/password"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWrongPassword      = errors.New("current password is incorrect")
+	ErrEmailTaken         = errors.New("email already registered")
+	ErrSameEmail          = errors.New("new email is the same as the current one")
+	ErrInvalidEmailChange = errors.New("confirmation link is invalid or has expired")
+	ErrReauthRequired     = errors.New("log in again with a login link or your identity provider to confirm this change")
+)
+
+// reauthWindow is how recently users without a password must have logged in
+// to make changes that otherwise need the current password.
+const reauthWindow = 10 * time.Minute
+
+// Profile is what a user sees about their own account.
+type Profile struct {
+	*models.User
+	HasPassword  bool   `json:"has_password"`
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+// ProfileUpdate holds the fields a user may change directly; nil fields are
+// left as they are.
+type ProfileUpdate struct {
+	DisplayName *string
+}
+
+type AccountService interface {
+	GetProfile(userID uint) (*Profile, error)
+	UpdateProfile(userID uint, update ProfileUpdate, meta models.RequestMeta) (*Profile, error)
+	ChangePassword(userID, sessionID uint, currentPassword, newPassword string, meta models.RequestMeta) error
+	RequestEmailChange(userID, sessionID uint, newEmail, currentPassword string, meta models.RequestMeta) error
+	ConfirmEmailChange(token string, meta models.RequestMeta) (*models.User, error)
+	VerifyPassword(user *models.User, sessionID uint, currentPassword string, meta models.RequestMeta) error
+}
+
+type accountService struct {
+	userRepo        repositories.UserRepository
+	emailChangeRepo repositories.EmailChangeRepository
+	sessions        SessionService
+	loginThrottle   LoginThrottleService
+	auditService    AuditService
+	notifier        notify.Notifier
+	passwords       *password.Validator
+	hasher          *password.MultiHasher
+	emailPolicy     *emailpolicy.Policy
+	emailChangeURL  string
+	emailChangeTTL  time.Duration
+}
+
+func NewAccountService(userRepo repositories.UserRepository, emailChangeRepo repositories.EmailChangeRepository, sessions SessionService, loginThrottle LoginThrottleService, auditService AuditService, notifier notify.Notifier, passwords *password.Validator, hasher *password.MultiHasher, emailPolicy *emailpolicy.Policy, emailChangeURL string, emailChangeTTL time.Duration) AccountService {
+	return &accountService{
+		userRepo:        userRepo,
+		emailChangeRepo: emailChangeRepo,
+		sessions:        sessions,
+		loginThrottle:   loginThrottle,
+		auditService:    auditService,
+		notifier:        notifier,
+		passwords:       passwords,
+		hasher:          hasher,
+		emailPolicy:     emailPolicy,
+		emailChangeURL:  emailChangeURL,
+		emailChangeTTL:  emailChangeTTL,
+	}
+}
+
+func (s *accountService) GetProfile(userID uint) (*Profile, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.profile(user)
+}
+
+func (s *accountService) UpdateProfile(userID uint, update ProfileUpdate, meta models.RequestMeta) (*Profile, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	before := profileAudit{DisplayName: user.DisplayName}
+	if update.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*update.DisplayName)
+	}
+
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionProfileUpdate,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     before,
+		After:      profileAudit{DisplayName: user.DisplayName},
+	})
+
+	return s.profile(user)
+}
+
+// ChangePassword sets a new password and logs out every other session. An
+// account created without a password can set its first one right after
+// logging in, without supplying a current password.
+func (s *accountService) ChangePassword(userID, sessionID uint, currentPassword, newPassword string, meta models.RequestMeta) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.VerifyPassword(user, sessionID, currentPassword, meta); err != nil {
+		return err
+	}
+
+	if err := s.passwords.Validate(newPassword, user.Email); err != nil {
+		return err
+	}
+	hash, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	hadPassword := user.PasswordHash != ""
+	user.PasswordHash = hash
+	if err := s.userRepo.Update(user); err != nil {
+		return err
+	}
+
+	// Whoever else might know the old password should not stay logged in.
+	if err := s.sessions.RevokeOthers(user.ID, sessionID); err != nil {
+		log.Printf("end sessions of user %d after password change: %v", user.ID, err)
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionPasswordChange,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		After:      passwordChangeAudit{FirstPassword: !hadPassword},
+	})
+
+	s.notify(user.ID, notify.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("The password of your account was changed from %s and all other devices were logged out. "+
+			"If this was not you, reset your password right away.", meta.IP),
+	})
+	return nil
+}
+
+// RequestEmailChange sends a confirmation link to the new address. The
+// account keeps its current address until the link is followed. The new
+// address is checked against the email policy like at signup; an alias of
+// the current address is allowed, an alias of another account's is not.
+func (s *accountService) RequestEmailChange(userID, sessionID uint, newEmail, currentPassword string, meta models.RequestMeta) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.VerifyPassword(user, sessionID, currentPassword, meta); err != nil {
+		return err
+	}
+
+	newEmail = strings.TrimSpace(newEmail)
+	if newEmail == user.Email {
+		return ErrSameEmail
+	}
+	if _, err := s.emailPolicy.Check(newEmail); err != nil {
+		return err
+	}
+	if err := s.checkEmailAvailable(newEmail, user); err != nil {
+		return err
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+	link, err := linkWithToken(s.emailChangeURL, token)
+	if err != nil {
+		return err
+	}
+
+	change := &models.EmailChange{
+		UserID:    user.ID,
+		NewEmail:  newEmail,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(s.emailChangeTTL),
+	}
+	if err := s.emailChangeRepo.Create(change); err != nil {
+		return err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionEmailChangeRequest,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		After:      emailAudit{Email: newEmail},
+	})
+
+	s.notify(user.ID, notify.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Use this link to make %s the email address of your account:\n\n%s\n\nThe link expires in %s. If you did not ask for this, you can ignore this email.",
+			newEmail, link, s.emailChangeTTL.Round(time.Minute)),
+	})
+	s.notify(user.ID, notify.Message{
+		To:      user.Email,
+		Subject: "Email change requested",
+		Body:    fmt.Sprintf("Someone asked to change the email address of your account to %s. It only changes once the new address is confirmed. If this was not you, change your password.", newEmail),
+	})
+	return nil
+}
+
+// ConfirmEmailChange switches the account to the confirmed address. Codes and
+// referrals are tied to the user rather than the address, so they carry over.
+func (s *accountService) ConfirmEmailChange(token string, meta models.RequestMeta) (*models.User, error) {
+	change, err := s.emailChangeRepo.Consume(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidEmailChange
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(change.ExpiresAt) {
+		return nil, ErrInvalidEmailChange
+	}
+
+	user, err := s.userRepo.GetByID(change.UserID)
+	if err != nil {
+		return nil, ErrInvalidEmailChange
+	}
+	if !user.IsActive(time.Now()) {
+		return nil, ErrAccountInactive
+	}
+
+	// The address may have been registered, or its domain listed as
+	// disposable, since the change was requested.
+	flag, err := s.emailPolicy.Check(change.NewEmail)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkEmailAvailable(change.NewEmail, user); err != nil {
+		return nil, err
+	}
+
+	oldEmail := user.Email
+	now := time.Now()
+	user.Email = change.NewEmail
+	user.EmailVerifiedAt = &now
+	if flag != "" {
+		user.EmailFlag = flag
+	}
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionEmailChange,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     emailAudit{Email: oldEmail},
+		After:      emailAudit{Email: user.Email},
+	})
+
+	s.notify(user.ID, notify.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body:    fmt.Sprintf("Your account now uses %s. Messages will no longer be sent to this address.", user.Email),
+	})
+	return user, nil
+}
+
+// VerifyPassword requires the current password of accounts that have one.
+// Wrong guesses count towards the login throttle like failed logins. Accounts
+// without a password instead need a session that was started within the
+// reauthentication window, so a stolen older token is not enough.
+func (s *accountService) VerifyPassword(user *models.User, sessionID uint, currentPassword string, meta models.RequestMeta) error {
+	if user.PasswordHash == "" {
+		fresh, err := s.sessions.Fresh(user.ID, sessionID, reauthWindow)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrReauthRequired
+		}
+		return nil
+	}
+
+	if err := s.loginThrottle.Check(user.Email, user, meta.IP); err != nil {
+		return err
+	}
+
+	ok, _, err := s.hasher.Verify(user.PasswordHash, currentPassword)
+	if err != nil {
+		log.Printf("verify password of user %d: %v", user.ID, err)
+	}
+	if !ok {
+		s.loginThrottle.RecordFailure(user.Email, user, meta)
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// checkEmailAvailable reports whether user may take the address: no other
+// account has it or an alias of it.
+func (s *accountService) checkEmailAvailable(email string, user *models.User) error {
+	existing, err := s.userRepo.GetByEmail(email)
+	switch {
+	case err == nil && existing.ID == user.ID:
+		return nil
+	case err == nil:
+		return ErrEmailTaken
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil
+	default:
+		return err
+	}
+}
+
+func (s *accountService) profile(user *models.User) (*Profile, error) {
+	profile := &Profile{User: user, HasPassword: user.PasswordHash != ""}
+
+	change, err := s.emailChangeRepo.GetPending(user.ID, time.Now())
+	switch {
+	case err == nil:
+		profile.PendingEmail = change.NewEmail
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (s *accountService) notify(userID uint, msg notify.Message) {
+	go func() {
+		if err := s.notifier.Notify(msg); err != nil {
+			log.Printf("account notification for user %d: %v", userID, err)
+		}
+	}()
+}
+
+type profileAudit struct {
+	DisplayName string `json:"display_name"`
+}
+
+type passwordChangeAudit struct {
+	FirstPassword bool `json:"first_password"`
+}
+
+type emailAudit struct {
+	Email string `json:"email"`
+}
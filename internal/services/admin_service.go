@@ -0,0 +1,238 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+)
+
+var (
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrInvalidStatus       = errors.New("invalid account status")
+	ErrInvalidStatusExpiry = errors.New("only suspensions can expire, and the expiry must be in the future")
+	ErrCannotModifySelf    = errors.New("admins cannot change their own role or status")
+	ErrInsufficientRole    = errors.New("cannot change the status of a user with an equal or higher role")
+)
+
+type UserSearchOptions struct {
+	Email    string
+	Role     string
+	Status   string
+	Page     int
+	PageSize int
+}
+
+type AdminService interface {
+	SearchUsers(opts UserSearchOptions) ([]models.User, int64, error)
+	GetUser(userID uint) (*models.User, error)
+	RevokeReferralCodes(userID uint, meta models.RequestMeta) (int64, error)
+	SetUserStatus(actorID, userID uint, status, reason string, expiresAt *time.Time, meta models.RequestMeta) (*models.User, error)
+	GetStatusHistory(userID uint) ([]models.UserStatusChange, error)
+	SetUserRole(actorID, userID uint, role string, meta models.RequestMeta) (*models.User, error)
+	UnlockUser(userID uint, meta models.RequestMeta) error
+}
+
+type adminService struct {
+	userRepo      repositories.UserRepository
+	promoCodeRepo repositories.PromoCodeRepository
+	loginThrottle LoginThrottleService
+	auditService  AuditService
+}
+
+func NewAdminService(userRepo repositories.UserRepository, promoCodeRepo repositories.PromoCodeRepository, loginThrottle LoginThrottleService, auditService AuditService) AdminService {
+	return &adminService{
+		userRepo:      userRepo,
+		promoCodeRepo: promoCodeRepo,
+		loginThrottle: loginThrottle,
+		auditService:  auditService,
+	}
+}
+
+func (s *adminService) SearchUsers(opts UserSearchOptions) ([]models.User, int64, error) {
+	return s.userRepo.Search(repositories.UserFilter{
+		Email:  opts.Email,
+		Role:   opts.Role,
+		Status: opts.Status,
+		Limit:  opts.PageSize,
+		Offset: (opts.Page - 1) * opts.PageSize,
+	})
+}
+
+func (s *adminService) GetUser(userID uint) (*models.User, error) {
+	return s.userRepo.GetByID(userID)
+}
+
+// RevokeReferralCodes removes the user's personal code and revokes every promo
+// code they own. It returns the number of promo codes revoked.
+func (s *adminService) RevokeReferralCodes(userID uint, meta models.RequestMeta) (int64, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	before := referralCodeState(user)
+	if user.ReferralCode != "" {
+		clearReferralCode(user)
+		if err := s.userRepo.Update(user); err != nil {
+			return 0, err
+		}
+	}
+
+	revoked, err := s.promoCodeRepo.RevokeByOwner(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionReferralCodeDelete,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     before,
+		After:      codesRevokedAudit{referralCodeAudit: referralCodeState(user), RevokedPromoCodes: revoked},
+	})
+
+	return revoked, nil
+}
+
+// SetUserStatus suspends, bans or reinstates an account. Suspended and banned
+// users lose their referral codes; reinstating does not bring them back.
+// Only users with a lower role than the actor's can be changed. The account,
+// its status history and its promo codes are updated in one transaction.
+func (s *adminService) SetUserStatus(actorID, userID uint, status, reason string, expiresAt *time.Time, meta models.RequestMeta) (*models.User, error) {
+	switch status {
+	case models.UserStatusActive, models.UserStatusSuspended, models.UserStatusBanned:
+	default:
+		return nil, ErrInvalidStatus
+	}
+
+	now := time.Now()
+	if expiresAt != nil && (status != models.UserStatusSuspended || !expiresAt.After(now)) {
+		return nil, ErrInvalidStatusExpiry
+	}
+
+	if actorID == userID {
+		return nil, ErrCannotModifySelf
+	}
+
+	actor, err := s.userRepo.GetByID(actorID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !models.Outranks(actor.Role, user.Role) {
+		return nil, ErrInsufficientRole
+	}
+
+	before := statusState(user)
+	user.Status = status
+	user.StatusReason = reason
+	user.StatusExpiresAt = expiresAt
+	user.StatusChangedBy = &actorID
+	user.StatusChangedAt = &now
+	if status != models.UserStatusActive {
+		clearReferralCode(user)
+	}
+
+	change := &models.UserStatusChange{
+		UserID:    user.ID,
+		Status:    status,
+		Reason:    reason,
+		ExpiresAt: expiresAt,
+		ChangedBy: actorID,
+	}
+	revoked, err := s.userRepo.ChangeStatus(user, change, status != models.UserStatusActive)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionStatusChange,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     before,
+		After:      statusChangeAudit{statusAudit: statusState(user), RevokedPromoCodes: revoked},
+	})
+
+	return user, nil
+}
+
+func (s *adminService) GetStatusHistory(userID uint) ([]models.UserStatusChange, error) {
+	if _, err := s.userRepo.GetByID(userID); err != nil {
+		return nil, err
+	}
+	return s.userRepo.GetStatusChanges(userID)
+}
+
+func (s *adminService) SetUserRole(actorID, userID uint, role string, meta models.RequestMeta) (*models.User, error) {
+	if !models.ValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+	if actorID == userID {
+		return nil, ErrCannotModifySelf
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	before := user.Role
+	user.Role = role
+
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionRoleChange,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     roleAudit{Role: before},
+		After:      roleAudit{Role: role},
+	})
+
+	return user, nil
+}
+
+// UnlockUser lifts a lockout caused by failed logins. Throttling of the IPs
+// involved stays in place.
+func (s *adminService) UnlockUser(userID uint, meta models.RequestMeta) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	return s.loginThrottle.Unlock(user, meta)
+}
+
+type codesRevokedAudit struct {
+	referralCodeAudit
+	RevokedPromoCodes int64 `json:"revoked_promo_codes"`
+}
+
+type roleAudit struct {
+	Role string `json:"role"`
+}
+
+type statusAudit struct {
+	Status    string     `json:"status"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type statusChangeAudit struct {
+	statusAudit
+	RevokedPromoCodes int64 `json:"revoked_promo_codes,omitempty"`
+}
+
+func statusState(user *models.User) statusAudit {
+	return statusAudit{Status: user.Status, Reason: user.StatusReason, ExpiresAt: user.StatusExpiresAt}
+}
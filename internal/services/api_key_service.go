@@ -0,0 +1,202 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"gorm.io/gorm"
+)
+
+// API keys look like rk_<prefix>_<secret>. The prefix identifies the key in
+// lookups and listings; the secret is only ever shown once.
+const (
+	apiKeyTag          = "rk_"
+	apiKeyPrefixBytes  = 6
+	apiKeySecretBytes  = 32
+	apiKeyPrefixLength = apiKeyPrefixBytes * 2
+)
+
+var (
+	ErrInvalidAPIKey      = errors.New("invalid API key")
+	ErrAPIKeyNotFound     = errors.New("API key not found")
+	ErrInvalidScope       = errors.New("invalid scope")
+	ErrNoScopes           = errors.New("at least one scope is required")
+	ErrAPIKeyExpiryInPast = errors.New("expiry must be in the future")
+)
+
+type APIKeyService interface {
+	Create(userID uint, name string, scopes []string, expiresAt *time.Time, meta models.RequestMeta) (*models.APIKey, string, error)
+	List(userID uint) ([]models.APIKey, error)
+	Revoke(userID, keyID uint, meta models.RequestMeta) error
+	Authenticate(rawKey string) (*models.APIKey, *models.User, error)
+}
+
+type apiKeyService struct {
+	apiKeyRepo   repositories.APIKeyRepository
+	userRepo     repositories.UserRepository
+	auditService AuditService
+}
+
+func NewAPIKeyService(apiKeyRepo repositories.APIKeyRepository, userRepo repositories.UserRepository, auditService AuditService) APIKeyService {
+	return &apiKeyService{
+		apiKeyRepo:   apiKeyRepo,
+		userRepo:     userRepo,
+		auditService: auditService,
+	}
+}
+
+// IsAPIKey tells API keys apart from JWTs in the Authorization header.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyTag)
+}
+
+// Create issues a new key and returns it along with the plain key, which
+// cannot be recovered later.
+func (s *apiKeyService) Create(userID uint, name string, scopes []string, expiresAt *time.Time, meta models.RequestMeta) (*models.APIKey, string, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", ErrNoScopes
+	}
+	for _, scope := range scopes {
+		if !models.ValidScope(user.Role, scope) {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrAPIKeyExpiryInPast
+	}
+
+	prefix, err := randomHex(apiKeyPrefixBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(apiKeySecretBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	rawKey := apiKeyTag + prefix + "_" + secret
+
+	key := &models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hashToken(rawKey),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.apiKeyRepo.Create(key); err != nil {
+		return nil, "", err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionAPIKeyCreate,
+		TargetType: models.AuditTargetAPIKey,
+		TargetID:   &key.ID,
+		After:      apiKeyAudit{Name: key.Name, Prefix: key.Prefix, Scopes: key.Scopes, ExpiresAt: key.ExpiresAt},
+	})
+
+	return key, rawKey, nil
+}
+
+func (s *apiKeyService) List(userID uint) ([]models.APIKey, error) {
+	return s.apiKeyRepo.ListForUser(userID)
+}
+
+func (s *apiKeyService) Revoke(userID, keyID uint, meta models.RequestMeta) error {
+	key, err := s.apiKeyRepo.GetForUser(userID, keyID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+
+	if err := s.apiKeyRepo.Revoke(key.ID, time.Now()); err != nil {
+		return err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionAPIKeyRevoke,
+		TargetType: models.AuditTargetAPIKey,
+		TargetID:   &key.ID,
+		Before:     apiKeyAudit{Name: key.Name, Prefix: key.Prefix, Scopes: key.Scopes, ExpiresAt: key.ExpiresAt},
+	})
+	return nil
+}
+
+// Authenticate looks up the key by its prefix and checks the secret. The
+// owner is returned as well; whether the account is active is left to the
+// caller, as it is for JWTs.
+func (s *apiKeyService) Authenticate(rawKey string) (*models.APIKey, *models.User, error) {
+	rest, ok := strings.CutPrefix(rawKey, apiKeyTag)
+	if !ok || len(rest) <= apiKeyPrefixLength || rest[apiKeyPrefixLength] != '_' {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.apiKeyRepo.GetByPrefix(rest[:apiKeyPrefixLength])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashToken(rawKey))) != 1 {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if !key.Usable(now) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.userRepo.GetByID(key.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if err := s.apiKeyRepo.TouchLastUsed(key.ID, now); err != nil {
+		log.Printf("api key %d: %v", key.ID, err)
+	}
+	return key, user, nil
+}
+
+// hashToken hashes random secrets such as API keys for storage. Plain SHA-256
+// is enough: unlike a password the secret is long and random, so it cannot be
+// guessed and needs no slow hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type apiKeyAudit struct {
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+)
+
+const auditVerifyBatchSize = 1000
+
+var errAuditChainBroken = errors.New("audit chain broken")
+
+// AuditEvent is what a service reports to the audit log. Before and After are
+// stored as JSON and must never contain secrets such as passwords.
+type AuditEvent struct {
+	Meta       models.RequestMeta
+	Action     string
+	TargetType string
+	TargetID   *uint
+	Before     any
+	After      any
+}
+
+type AuditListOptions struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	IP         string
+	From       time.Time
+	To         time.Time
+	Page       int
+	PageSize   int
+}
+
+type AuditService interface {
+	Record(event AuditEvent)
+	ListEntries(opts AuditListOptions) ([]models.AuditEntry, int64, error)
+	VerifyChain() (*models.AuditVerification, error)
+}
+
+type auditService struct {
+	auditRepo repositories.AuditRepository
+}
+
+func NewAuditService(auditRepo repositories.AuditRepository) AuditService {
+	return &auditService{auditRepo: auditRepo}
+}
+
+// Record appends an event to the audit log. A failure to record is logged
+// rather than returned, since the change it describes has already been made.
+func (s *auditService) Record(event AuditEvent) {
+	entry := &models.AuditEntry{
+		ActorID:    event.Meta.ActorID,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		IP:         event.Meta.IP,
+		UserAgent:  event.Meta.UserAgent,
+	}
+
+	var err error
+	if entry.Before, err = auditValues(event.Before); err == nil {
+		entry.After, err = auditValues(event.After)
+	}
+	if err == nil {
+		err = s.auditRepo.Append(entry)
+	}
+	if err != nil {
+		log.Printf("audit %s: %v", event.Action, err)
+	}
+}
+
+func (s *auditService) ListEntries(opts AuditListOptions) ([]models.AuditEntry, int64, error) {
+	return s.auditRepo.List(repositories.AuditFilter{
+		ActorID:    opts.ActorID,
+		Action:     opts.Action,
+		TargetType: opts.TargetType,
+		TargetID:   opts.TargetID,
+		IP:         opts.IP,
+		From:       opts.From,
+		To:         opts.To,
+		Limit:      opts.PageSize,
+		Offset:     (opts.Page - 1) * opts.PageSize,
+	})
+}
+
+// VerifyChain recomputes every hash in insertion order and reports the first
+// entry that was modified, or whose predecessor was modified or removed. The
+// details of redacted entries can no longer be checked against their hash,
+// so any details left on them count as a modification.
+func (s *auditService) VerifyChain() (*models.AuditVerification, error) {
+	result := &models.AuditVerification{Valid: true}
+	prevHash := ""
+
+	err := s.auditRepo.Stream(auditVerifyBatchSize, func(entry *models.AuditEntry) error {
+		result.Checked++
+		if entry.PrevHash != prevHash || entry.ComputeHash() != entry.Hash || !entry.DetailsIntact() {
+			id := entry.ID
+			result.Valid = false
+			result.BrokenAt = &id
+			return errAuditChainBroken
+		}
+
+		prevHash = entry.Hash
+		return nil
+	})
+	if err != nil && !errors.Is(err, errAuditChainBroken) {
+		return nil, err
+	}
+	return result, nil
+}
+
+func auditValues(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,364 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/serlenario/referral-system/internal/export"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+)
+
+const (
+	MaxCodeBatchSize = 100000
+
+	codeBatchChunkSize = 500
+	// codeBatchLease is how long a batch stays claimed by the instance
+	// generating it. The lease is renewed after every chunk.
+	codeBatchLease  = 2 * time.Minute
+	promoCodeLength = 10
+	// Unambiguous characters only, since codes end up on printed flyers.
+	// 32 symbols keep the byte-to-symbol mapping unbiased.
+	promoCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	ErrCampaignNotFound      = errors.New("campaign not found")
+	ErrInvalidCampaignDates  = errors.New("campaign must end after it starts")
+	ErrCampaignNotStarted    = errors.New("campaign has not started yet")
+	ErrCampaignEnded         = errors.New("campaign has ended")
+	ErrCampaignFull          = errors.New("campaign has reached its participant limit")
+	ErrNotInCampaignAudience = errors.New("referrer is not eligible for this campaign")
+	ErrEmailDomainNotAllowed = errors.New("email domain is not allowed in this campaign")
+	ErrInvalidBatchSize      = fmt.Errorf("code count must be between 1 and %d", MaxCodeBatchSize)
+	ErrCodeBatchNotReady     = errors.New("code batch is not completed yet")
+	errTooManyCollisions     = errors.New("could not generate unique codes")
+)
+
+type CampaignInput struct {
+	Name            string
+	Description     string
+	StartsAt        *time.Time
+	EndsAt          *time.Time
+	ReferrerReward  int64
+	RefereeReward   int64
+	MaxParticipants int
+	TargetAudience  string
+	AllowedDomains  []string
+	BlockedDomains  []string
+}
+
+type CampaignService interface {
+	CreateCampaign(input CampaignInput) (*models.Campaign, error)
+	GetCampaign(id uint) (*models.Campaign, error)
+	ListCampaigns() ([]models.Campaign, error)
+	UpdateCampaign(id uint, input CampaignInput) (*models.Campaign, error)
+	DeleteCampaign(id uint) error
+	GetCampaignStats(id uint) (*models.CampaignStats, error)
+	GenerateCodes(campaignID, ownerID uint, count int, expiresAt *time.Time, createdBy uint) (*models.CodeBatch, error)
+	GetCodeBatch(id uint) (*models.CodeBatch, error)
+	ExportCodeBatch(id uint, w io.Writer) error
+	ImportCodes(r io.Reader, campaignID *uint, dryRun bool) (*models.CodeImportReport, error)
+	ResumeCodeBatches() error
+}
+
+type campaignService struct {
+	campaignRepo  repositories.CampaignRepository
+	promoCodeRepo repositories.PromoCodeRepository
+	userRepo      repositories.UserRepository
+	// instanceID identifies this instance as the holder of code batch leases.
+	instanceID string
+}
+
+func NewCampaignService(campaignRepo repositories.CampaignRepository, promoCodeRepo repositories.PromoCodeRepository, userRepo repositories.UserRepository) CampaignService {
+	return &campaignService{
+		campaignRepo:  campaignRepo,
+		promoCodeRepo: promoCodeRepo,
+		userRepo:      userRepo,
+		instanceID:    uuid.New().String(),
+	}
+}
+
+func (s *campaignService) CreateCampaign(input CampaignInput) (*models.Campaign, error) {
+	campaign := &models.Campaign{}
+	if err := applyCampaignInput(campaign, input); err != nil {
+		return nil, err
+	}
+
+	return campaign, s.campaignRepo.Create(campaign)
+}
+
+func (s *campaignService) GetCampaign(id uint) (*models.Campaign, error) {
+	return s.campaignRepo.GetByID(id)
+}
+
+func (s *campaignService) ListCampaigns() ([]models.Campaign, error) {
+	return s.campaignRepo.List()
+}
+
+func (s *campaignService) UpdateCampaign(id uint, input CampaignInput) (*models.Campaign, error) {
+	campaign, err := s.campaignRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyCampaignInput(campaign, input); err != nil {
+		return nil, err
+	}
+
+	return campaign, s.campaignRepo.Update(campaign)
+}
+
+func (s *campaignService) DeleteCampaign(id uint) error {
+	if _, err := s.campaignRepo.GetByID(id); err != nil {
+		return err
+	}
+	return s.campaignRepo.Delete(id)
+}
+
+func (s *campaignService) GetCampaignStats(id uint) (*models.CampaignStats, error) {
+	if _, err := s.campaignRepo.GetByID(id); err != nil {
+		return nil, err
+	}
+	return s.campaignRepo.GetStats(id)
+}
+
+func applyCampaignInput(campaign *models.Campaign, input CampaignInput) error {
+	if input.StartsAt != nil && input.EndsAt != nil && !input.EndsAt.After(*input.StartsAt) {
+		return ErrInvalidCampaignDates
+	}
+
+	audience := input.TargetAudience
+	if audience == "" {
+		audience = models.AudienceAll
+	}
+
+	campaign.Name = input.Name
+	campaign.Description = input.Description
+	campaign.StartsAt = input.StartsAt
+	campaign.EndsAt = input.EndsAt
+	campaign.ReferrerReward = input.ReferrerReward
+	campaign.RefereeReward = input.RefereeReward
+	campaign.MaxParticipants = input.MaxParticipants
+	campaign.TargetAudience = audience
+	campaign.AllowedDomains = normalizeDomains(input.AllowedDomains)
+	campaign.BlockedDomains = normalizeDomains(input.BlockedDomains)
+	return nil
+}
+
+func normalizeDomains(domains []string) []string {
+	var normalized []string
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			normalized = append(normalized, domain)
+		}
+	}
+	return normalized
+}
+
+// GenerateCodes records a code batch and generates its codes in the
+// background. Progress can be followed with GetCodeBatch.
+func (s *campaignService) GenerateCodes(campaignID, ownerID uint, count int, expiresAt *time.Time, createdBy uint) (*models.CodeBatch, error) {
+	if count < 1 || count > MaxCodeBatchSize {
+		return nil, ErrInvalidBatchSize
+	}
+
+	campaign, err := s.campaignRepo.GetByID(campaignID)
+	if err != nil {
+		return nil, ErrCampaignNotFound
+	}
+	if campaign.Ended(time.Now()) {
+		return nil, ErrCampaignEnded
+	}
+
+	if _, err := s.userRepo.GetByID(ownerID); err != nil {
+		return nil, errors.New("owner not found")
+	}
+
+	batch := &models.CodeBatch{
+		CampaignID: campaignID,
+		OwnerID:    ownerID,
+		CreatedBy:  createdBy,
+		Requested:  count,
+		ExpiresAt:  expiresAt,
+		Status:     models.CodeBatchStatusPending,
+	}
+
+	if err := s.campaignRepo.CreateCodeBatch(batch); err != nil {
+		return nil, err
+	}
+
+	go s.runCodeBatch(batch.ID)
+
+	return batch, nil
+}
+
+func (s *campaignService) GetCodeBatch(id uint) (*models.CodeBatch, error) {
+	return s.campaignRepo.GetCodeBatch(id)
+}
+
+func (s *campaignService) ExportCodeBatch(id uint, w io.Writer) error {
+	batch, err := s.campaignRepo.GetCodeBatch(id)
+	if err != nil {
+		return err
+	}
+
+	if batch.Status != models.CodeBatchStatusCompleted {
+		return ErrCodeBatchNotReady
+	}
+
+	writer, err := export.NewWriter(export.FormatCSV, w, []string{"code", "campaign_id", "owner_id", "max_uses", "expires_at"})
+	if err != nil {
+		return err
+	}
+
+	err = s.promoCodeRepo.StreamByBatch(batch.ID, exportBatchSize, func(code *models.PromoCode) error {
+		return writer.Write(code.Code, code.CampaignID, code.OwnerID, code.MaxUses, code.ExpiresAt)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// ResumeCodeBatches restarts batches that were interrupted by a shutdown.
+// Codes already generated are kept, only the remainder is produced. Batches
+// still being generated by another instance are left alone.
+func (s *campaignService) ResumeCodeBatches() error {
+	batches, err := s.campaignRepo.GetUnfinishedCodeBatches()
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		go s.runCodeBatch(batch.ID)
+	}
+	return nil
+}
+
+func (s *campaignService) runCodeBatch(id uint) {
+	now := time.Now()
+	claimed, err := s.campaignRepo.ClaimCodeBatch(id, s.instanceID, now, now.Add(codeBatchLease))
+	if err != nil {
+		log.Printf("code batch %d: %v", id, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	// Load the batch only now, since another instance may have made
+	// progress before its lease ran out.
+	batch, err := s.campaignRepo.GetCodeBatch(id)
+	if err != nil {
+		log.Printf("code batch %d: %v", id, err)
+		return
+	}
+
+	// Progress is only saved after each chunk, so count what is actually
+	// stored in case the previous run stopped in between.
+	generated, err := s.promoCodeRepo.CountByBatch(batch.ID)
+	if err != nil {
+		log.Printf("code batch %d: %v", batch.ID, err)
+		return
+	}
+
+	batch.Generated = int(generated)
+	batch.Status = models.CodeBatchStatusRunning
+	if err := s.campaignRepo.UpdateCodeBatch(batch, s.instanceID, time.Now()); err != nil {
+		log.Printf("code batch %d: %v", batch.ID, err)
+		return
+	}
+
+	err = s.generateRemainingCodes(batch)
+	if errors.Is(err, repositories.ErrCodeBatchLeaseLost) {
+		// The lease ran out, so another instance may already be generating
+		// the rest. Whichever instance resumes the batch next finishes it.
+		log.Printf("code batch %d: %v", batch.ID, err)
+		return
+	}
+	if err != nil {
+		log.Printf("code batch %d failed: %v", batch.ID, err)
+		batch.Status = models.CodeBatchStatusFailed
+		batch.Error = err.Error()
+	} else {
+		now := time.Now()
+		batch.Status = models.CodeBatchStatusCompleted
+		batch.CompletedAt = &now
+	}
+	batch.LeaseOwner = ""
+	batch.LeaseUntil = nil
+
+	if err := s.campaignRepo.UpdateCodeBatch(batch, s.instanceID, time.Now()); err != nil {
+		log.Printf("code batch %d: %v", batch.ID, err)
+	}
+}
+
+func (s *campaignService) generateRemainingCodes(batch *models.CodeBatch) error {
+	campaignID := batch.CampaignID
+	batchID := batch.ID
+	emptyChunks := 0
+
+	for batch.Generated < batch.Requested {
+		size := min(codeBatchChunkSize, batch.Requested-batch.Generated)
+
+		codes := make([]models.PromoCode, size)
+		for i := range codes {
+			code, err := randomPromoCode()
+			if err != nil {
+				return err
+			}
+			codes[i] = models.PromoCode{
+				Code:       code,
+				CampaignID: &campaignID,
+				OwnerID:    batch.OwnerID,
+				BatchID:    &batchID,
+				MaxUses:    1,
+				ExpiresAt:  batch.ExpiresAt,
+			}
+		}
+
+		// Collisions are skipped by the insert and simply retried in the next chunk.
+		inserted, err := s.promoCodeRepo.CreateIgnoringDuplicates(codes)
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			emptyChunks++
+			if emptyChunks >= 3 {
+				return errTooManyCollisions
+			}
+			continue
+		}
+		emptyChunks = 0
+
+		batch.Generated += int(inserted)
+		now := time.Now()
+		leaseUntil := now.Add(codeBatchLease)
+		batch.LeaseUntil = &leaseUntil
+		if err := s.campaignRepo.UpdateCodeBatch(batch, s.instanceID, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func randomPromoCode() (string, error) {
+	buf := make([]byte, promoCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	for i, b := range buf {
+		buf[i] = promoCodeAlphabet[int(b)%len(promoCodeAlphabet)]
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+	"github.com/serlenario/referral-system/internal/models"
+)
+
+const MaxImportRows = 100000
+
+var (
+	ErrInvalidImportFile    = errors.New("invalid CSV file")
+	ErrImportMissingColumns = errors.New("CSV must have code and owner_email columns")
+	ErrImportTooManyRows    = fmt.Errorf("CSV must not have more than %d rows", MaxImportRows)
+
+	importedCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{4,64}$`)
+)
+
+type importRow struct {
+	line       int
+	code       string
+	ownerEmail string
+	expiresAt  *time.Time
+	maxUses    int
+}
+
+// ImportCodes validates every row of a partner CSV with the columns code,
+// owner_email and the optional expires_at and max_uses. Unless dryRun is set,
+// the valid rows are stored in a single transaction; invalid rows are only
+// reported.
+func (s *campaignService) ImportCodes(r io.Reader, campaignID *uint, dryRun bool) (*models.CodeImportReport, error) {
+	if campaignID != nil {
+		campaign, err := s.campaignRepo.GetByID(*campaignID)
+		if err != nil {
+			return nil, ErrCampaignNotFound
+		}
+		if campaign.Ended(time.Now()) {
+			return nil, ErrCampaignEnded
+		}
+	}
+
+	rows, report, err := parseImportCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = dryRun
+
+	rows, owners, err := s.validateImportRows(rows, report)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ValidRows = len(rows)
+	if dryRun || len(rows) == 0 {
+		return report, nil
+	}
+
+	codes := make([]models.PromoCode, 0, len(rows))
+	for _, row := range rows {
+		codes = append(codes, models.PromoCode{
+			Code:       row.code,
+			CampaignID: campaignID,
+			OwnerID:    owners[emailpolicy.Normalize(row.ownerEmail)],
+			MaxUses:    row.maxUses,
+			ExpiresAt:  row.expiresAt,
+		})
+	}
+
+	if err := s.promoCodeRepo.CreateAll(codes); err != nil {
+		return nil, err
+	}
+	report.Imported = len(codes)
+
+	return report, nil
+}
+
+func parseImportCSV(r io.Reader) ([]importRow, *models.CodeImportReport, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["code"]; !ok {
+		return nil, nil, ErrImportMissingColumns
+	}
+	if _, ok := columns["owner_email"]; !ok {
+		return nil, nil, ErrImportMissingColumns
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	report := &models.CodeImportReport{Errors: []models.CodeImportError{}}
+	var rows []importRow
+	now := time.Now()
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		report.TotalRows++
+		if report.TotalRows > MaxImportRows {
+			return nil, nil, ErrImportTooManyRows
+		}
+
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, err
+			}
+			report.Errors = append(report.Errors, models.CodeImportError{Row: parseErr.StartLine, Error: parseErr.Err.Error()})
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		row := importRow{
+			line:       line,
+			code:       field(record, "code"),
+			ownerEmail: field(record, "owner_email"),
+			maxUses:    1,
+		}
+
+		if rowErr := parseImportRow(&row, field(record, "expires_at"), field(record, "max_uses"), now); rowErr != "" {
+			report.Errors = append(report.Errors, models.CodeImportError{Row: line, Code: row.code, Error: rowErr})
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, report, nil
+}
+
+func parseImportRow(row *importRow, expiresAt, maxUses string, now time.Time) string {
+	if !importedCodePattern.MatchString(row.code) {
+		return "code must be 4-64 letters, digits, dashes or underscores"
+	}
+
+	if row.ownerEmail == "" {
+		return "owner_email is required"
+	}
+
+	if expiresAt != "" {
+		expiry, err := parseImportDate(expiresAt)
+		if err != nil {
+			return "expires_at must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+		}
+		if !expiry.After(now) {
+			return "expires_at is in the past"
+		}
+		row.expiresAt = &expiry
+	}
+
+	if maxUses != "" {
+		uses, err := strconv.Atoi(maxUses)
+		if err != nil || uses < 1 {
+			return "max_uses must be a positive integer"
+		}
+		row.maxUses = uses
+	}
+
+	return ""
+}
+
+func parseImportDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	// A bare date means the code is valid through the end of that day.
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, 1), nil
+}
+
+// validateImportRows drops rows with duplicate codes or unknown owners and
+// records why in the report. The owners of the remaining rows are returned
+// keyed by normalised email, so that any alias of an owner's address finds
+// them.
+func (s *campaignService) validateImportRows(rows []importRow, report *models.CodeImportReport) ([]importRow, map[string]uint, error) {
+	codes := make([]string, 0, len(rows))
+	for _, row := range rows {
+		codes = append(codes, row.code)
+	}
+
+	taken := make(map[string]bool)
+	existingPromo, err := s.promoCodeRepo.FindExistingCodes(codes)
+	if err != nil {
+		return nil, nil, err
+	}
+	existingPersonal, err := s.userRepo.FindExistingReferralCodes(codes)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, code := range append(existingPromo, existingPersonal...) {
+		taken[code] = true
+	}
+
+	owners, err := s.ownerIDsByEmail(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]int, len(rows))
+	valid := rows[:0]
+	for _, row := range rows {
+		var rowErr string
+		switch {
+		case taken[row.code]:
+			rowErr = "code already exists"
+		case seen[row.code] != 0:
+			rowErr = fmt.Sprintf("duplicate of row %d", seen[row.code])
+		case owners[emailpolicy.Normalize(row.ownerEmail)] == 0:
+			rowErr = "unknown owner email"
+		}
+
+		if rowErr != "" {
+			report.Errors = append(report.Errors, models.CodeImportError{Row: row.line, Code: row.code, Error: rowErr})
+		} else {
+			valid = append(valid, row)
+		}
+
+		if seen[row.code] == 0 {
+			seen[row.code] = row.line
+		}
+	}
+
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Row < report.Errors[j].Row
+	})
+
+	return valid, owners, nil
+}
+
+func (s *campaignService) ownerIDsByEmail(rows []importRow) (map[string]uint, error) {
+	unique := make(map[string]struct{}, len(rows))
+	emails := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if _, ok := unique[row.ownerEmail]; !ok {
+			unique[row.ownerEmail] = struct{}{}
+			emails = append(emails, row.ownerEmail)
+		}
+	}
+
+	users, err := s.userRepo.GetByEmails(emails)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]uint, len(users))
+	for _, user := range users {
+		owners[emailpolicy.Normalize(user.Email)] = user.ID
+	}
+	return owners, nil
+}
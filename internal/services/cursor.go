@@ -0,0 +1,30 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+type referralCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uint      `json:"id"`
+}
+
+func encodeReferralCursor(createdAt time.Time, id uint) string {
+	data, _ := json.Marshal(referralCursor{CreatedAt: createdAt, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeReferralCursor(cursor string) (time.Time, uint, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	var c referralCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == 0 {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return c.CreatedAt, c.ID, nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"io"
+
+	"github.com/serlenario/referral-system/internal/export"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"github.com/serlenario/referral-system/internal/utils"
+)
+
+const exportBatchSize = 500
+
+type ExportService interface {
+	ExportUserReferrals(userID uint, opts ReferralListOptions, format string, w io.Writer) error
+	ExportReferrals(referrerID uint, opts ReferralListOptions, format string, w io.Writer) error
+	ExportUsers(format string, w io.Writer) error
+	ExportRewards(format string, w io.Writer) error
+}
+
+type exportService struct {
+	userRepo     repositories.UserRepository
+	referralRepo repositories.ReferralRepository
+	rewardRepo   repositories.RewardRepository
+	emailMasker  utils.EmailMasker
+}
+
+func NewExportService(userRepo repositories.UserRepository, referralRepo repositories.ReferralRepository, rewardRepo repositories.RewardRepository, emailMasker utils.EmailMasker) ExportService {
+	return &exportService{
+		userRepo:     userRepo,
+		referralRepo: referralRepo,
+		rewardRepo:   rewardRepo,
+		emailMasker:  emailMasker,
+	}
+}
+
+// ExportUserReferrals exports the referrals of a single user with the same
+// masking applied as in the GET /referrals listing.
+func (s *exportService) ExportUserReferrals(userID uint, opts ReferralListOptions, format string, w io.Writer) error {
+	filter, err := referralFilter(userID, opts)
+	if err != nil {
+		return err
+	}
+
+	writer, err := export.NewWriter(format, w, []string{
+		"id", "referral_code", "status", "created_at", "referred_email", "referred_signed_up_at", "referred_verified",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.referralRepo.Stream(filter, exportBatchSize, func(referral *models.Referral) error {
+		details := referralDetails(referral, s.emailMasker)
+		return writer.Write(
+			details.ID,
+			details.ReferralCode,
+			details.Status,
+			details.CreatedAt,
+			details.ReferredEmail,
+			details.ReferredSignedUpAt,
+			details.ReferredVerified,
+		)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// ExportReferrals exports referrals across all users, or of a single referrer
+// when referrerID is set. Emails are not masked.
+func (s *exportService) ExportReferrals(referrerID uint, opts ReferralListOptions, format string, w io.Writer) error {
+	filter, err := referralFilter(referrerID, opts)
+	if err != nil {
+		return err
+	}
+
+	writer, err := export.NewWriter(format, w, []string{
+		"id", "referred_by", "referred_id", "referred_email", "referral_code", "campaign_id", "status", "created_at",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.referralRepo.Stream(filter, exportBatchSize, func(referral *models.Referral) error {
+		var referredEmail string
+		if referral.Referred != nil {
+			referredEmail = referral.Referred.Email
+		}
+		return writer.Write(
+			referral.ID,
+			referral.ReferredBy,
+			referral.ReferredID,
+			referredEmail,
+			referral.ReferralCode,
+			referral.CampaignID,
+			referral.Status,
+			referral.CreatedAt,
+		)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func (s *exportService) ExportUsers(format string, w io.Writer) error {
+	writer, err := export.NewWriter(format, w, []string{
+		"id", "email", "role", "referral_code", "referral_expiry", "display_name", "leaderboard_opt_out", "email_verified_at", "created_at",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepo.Stream(exportBatchSize, func(user *models.User) error {
+		return writer.Write(
+			user.ID,
+			user.Email,
+			user.Role,
+			user.ReferralCode,
+			user.ReferralExpiry,
+			user.DisplayName,
+			user.LeaderboardOptOut,
+			user.EmailVerifiedAt,
+			user.CreatedAt,
+		)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func (s *exportService) ExportRewards(format string, w io.Writer) error {
+	writer, err := export.NewWriter(format, w, []string{
+		"id", "user_id", "referral_id", "campaign_id", "kind", "amount", "status", "created_at",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.rewardRepo.Stream(exportBatchSize, func(reward *models.Reward) error {
+		return writer.Write(
+			reward.ID,
+			reward.UserID,
+			reward.ReferralID,
+			reward.CampaignID,
+			reward.Kind,
+			reward.Amount,
+			reward.Status,
+			reward.CreatedAt,
+		)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"github.com/serlenario/referral-system/internal/utils"
+)
+
+const (
+	LeaderboardPeriodAllTime = "all_time"
+	LeaderboardPeriodMonth   = "month"
+	LeaderboardPeriodWeek    = "week"
+)
+
+var ErrInvalidLeaderboardPeriod = errors.New("invalid leaderboard period")
+
+type LeaderboardService interface {
+	GetLeaderboard(period string, page, pageSize int) (*models.Leaderboard, error)
+	UpdatePreferences(userID uint, displayName string, optOut bool) (*models.User, error)
+}
+
+type leaderboardService struct {
+	userRepo     repositories.UserRepository
+	referralRepo repositories.ReferralRepository
+	emailMasker  utils.EmailMasker
+	cacheTTL     time.Duration
+
+	mu    sync.RWMutex
+	cache map[string]cachedLeaderboard
+}
+
+type cachedLeaderboard struct {
+	leaderboard *models.Leaderboard
+	expiresAt   time.Time
+}
+
+func NewLeaderboardService(userRepo repositories.UserRepository, referralRepo repositories.ReferralRepository, emailMasker utils.EmailMasker, cacheTTL time.Duration) LeaderboardService {
+	return &leaderboardService{
+		userRepo:     userRepo,
+		referralRepo: referralRepo,
+		emailMasker:  emailMasker,
+		cacheTTL:     cacheTTL,
+		cache:        make(map[string]cachedLeaderboard),
+	}
+}
+
+func (s *leaderboardService) GetLeaderboard(period string, page, pageSize int) (*models.Leaderboard, error) {
+	since, err := leaderboardPeriodStart(period, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	// The start of the period is part of the key, so that a new week or
+	// month does not serve the ranking of the previous one.
+	key := fmt.Sprintf("%s:%d:%d:%d", period, since.Unix(), page, pageSize)
+	if leaderboard, ok := s.cached(key); ok {
+		return leaderboard, nil
+	}
+
+	stats, total, err := s.referralRepo.GetTopReferrers(since, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.LeaderboardEntry, 0, len(stats))
+	for i, stat := range stats {
+		entries = append(entries, models.LeaderboardEntry{
+			Rank:          (page-1)*pageSize + i + 1,
+			DisplayName:   s.displayName(stat),
+			ReferralCount: stat.ReferralCount,
+		})
+	}
+
+	leaderboard := &models.Leaderboard{
+		Period:   period,
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+		Entries:  entries,
+	}
+	s.store(key, leaderboard)
+
+	return leaderboard, nil
+}
+
+func (s *leaderboardService) UpdatePreferences(userID uint, displayName string, optOut bool) (*models.User, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.DisplayName = strings.TrimSpace(displayName)
+	user.LeaderboardOptOut = optOut
+
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	// Opting out has to take effect immediately rather than after the TTL.
+	s.invalidate()
+
+	return user, nil
+}
+
+func (s *leaderboardService) cached(key string) (*models.Leaderboard, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.cache[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.leaderboard, true
+}
+
+func (s *leaderboardService) store(key string, leaderboard *models.Leaderboard) {
+	if s.cacheTTL <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range s.cache {
+		if now.After(entry.expiresAt) {
+			delete(s.cache, k)
+		}
+	}
+	s.cache[key] = cachedLeaderboard{leaderboard: leaderboard, expiresAt: now.Add(s.cacheTTL)}
+}
+
+func (s *leaderboardService) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cache = make(map[string]cachedLeaderboard)
+}
+
+// leaderboardPeriodStart returns the beginning of the contest period containing now.
+// Months and weeks are calendar based (weeks start on Monday) so that monthly
+// contests match the figures we used to compute by hand.
+func leaderboardPeriodStart(period string, now time.Time) (time.Time, error) {
+	switch period {
+	case LeaderboardPeriodAllTime:
+		return time.Time{}, nil
+	case LeaderboardPeriodMonth:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	case LeaderboardPeriodWeek:
+		daysSinceMonday := (int(now.Weekday()) + 6) % 7
+		return time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC), nil
+	default:
+		return time.Time{}, ErrInvalidLeaderboardPeriod
+	}
+}
+
+func (s *leaderboardService) displayName(stat models.ReferrerStats) string {
+	if stat.DisplayName != "" {
+		return stat.DisplayName
+	}
+	return s.emailMasker.Mask(stat.Email)
+}
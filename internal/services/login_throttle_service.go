@@ -0,0 +1,212 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/config"
+	"github.com/serlenario/referral-system/internal/emailpolicy"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/notify"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"gorm.io/gorm"
+)
+
+var ErrTooManyLoginAttempts = errors.New("too many failed login attempts")
+
+// LoginThrottledError is returned while an account or IP has to wait before
+// trying again. It matches ErrTooManyLoginAttempts with errors.Is.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", ErrTooManyLoginAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrTooManyLoginAttempts
+}
+
+type LoginThrottleService interface {
+	Check(email string, user *models.User, ip string) error
+	RecordFailure(email string, user *models.User, meta models.RequestMeta)
+	RecordSuccess(user *models.User)
+	Unlock(user *models.User, meta models.RequestMeta) error
+}
+
+type loginThrottleService struct {
+	throttleRepo repositories.LoginThrottleRepository
+	auditService AuditService
+	notifier     notify.Notifier
+	policy       config.LoginPolicy
+}
+
+func NewLoginThrottleService(throttleRepo repositories.LoginThrottleRepository, auditService AuditService, notifier notify.Notifier, policy config.LoginPolicy) LoginThrottleService {
+	return &loginThrottleService{
+		throttleRepo: throttleRepo,
+		auditService: auditService,
+		notifier:     notifier,
+		policy:       policy,
+	}
+}
+
+// Check returns a LoginThrottledError if the account or the IP is blocked.
+// user is nil when the email does not belong to any account.
+func (s *loginThrottleService) Check(email string, user *models.User, ip string) error {
+	now := time.Now()
+	var retryAfter time.Duration
+
+	for scope, subject := range throttleSubjects(email, user, ip) {
+		throttle, err := s.throttleRepo.Get(scope, subject)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if throttle.BlockedUntil != nil && throttle.BlockedUntil.After(now) {
+			retryAfter = max(retryAfter, throttle.BlockedUntil.Sub(now))
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LoginThrottledError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt against the account and the IP.
+// user is nil when the email does not belong to any account. Errors are
+// logged, as the caller is already rejecting the login.
+func (s *loginThrottleService) RecordFailure(email string, user *models.User, meta models.RequestMeta) {
+	now := time.Now()
+	windowStart := now.Add(-s.policy.FailureWindow)
+
+	for scope, subject := range throttleSubjects(email, user, meta.IP) {
+		throttle, err := s.throttleRepo.RecordFailure(scope, subject, now, windowStart)
+		if err != nil {
+			log.Printf("login throttle %s: %v", scope, err)
+			continue
+		}
+
+		delay, locked := s.delay(scope, throttle.Failures)
+		if delay <= 0 {
+			continue
+		}
+		if err := s.throttleRepo.Block(throttle.ID, now.Add(delay)); err != nil {
+			log.Printf("login throttle %s: %v", scope, err)
+			continue
+		}
+
+		// Lock only once per streak; further failures extend the lockout
+		// but would otherwise flood the user with notifications.
+		if locked && s.maxFailures(scope) == throttle.Failures {
+			s.lockedOut(scope, subject, user, meta, now.Add(delay))
+		}
+	}
+}
+
+// RecordSuccess clears the account's failures. The IP counter is kept so
+// that logging into one's own account does not reset a guessing spree.
+func (s *loginThrottleService) RecordSuccess(user *models.User) {
+	if err := s.throttleRepo.Reset(models.LoginThrottleAccount, accountThrottleSubject("", user)); err != nil {
+		log.Printf("login throttle: %v", err)
+	}
+}
+
+func (s *loginThrottleService) Unlock(user *models.User, meta models.RequestMeta) error {
+	if err := s.throttleRepo.Reset(models.LoginThrottleAccount, accountThrottleSubject("", user)); err != nil {
+		return err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionAccountUnlocked,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+	})
+	return nil
+}
+
+// delay returns how long the subject has to wait after its n-th failure, and
+// whether that wait is a lockout.
+func (s *loginThrottleService) delay(scope string, failures int) (time.Duration, bool) {
+	if limit := s.maxFailures(scope); limit > 0 && failures >= limit {
+		return s.policy.LockoutDuration, true
+	}
+	if scope != models.LoginThrottleAccount || s.policy.BackoffBase <= 0 {
+		return 0, false
+	}
+
+	delay := s.policy.BackoffBase
+	for i := 1; i < failures && delay < s.policy.BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, s.policy.BackoffMax), false
+}
+
+func (s *loginThrottleService) maxFailures(scope string) int {
+	if scope == models.LoginThrottleAccount {
+		return s.policy.MaxAccountFailures
+	}
+	return s.policy.MaxIPFailures
+}
+
+func (s *loginThrottleService) lockedOut(scope, subject string, user *models.User, meta models.RequestMeta, until time.Time) {
+	event := AuditEvent{
+		Meta:   meta,
+		Action: models.AuditActionLoginLocked,
+		After:  loginLockAudit{Scope: scope, Subject: subject, Until: until},
+	}
+	if user != nil && scope == models.LoginThrottleAccount {
+		event.TargetType = models.AuditTargetUser
+		event.TargetID = &user.ID
+	}
+	s.auditService.Record(event)
+
+	if user == nil || scope != models.LoginThrottleAccount {
+		return
+	}
+
+	msg := notify.Message{
+		To:      user.Email,
+		Subject: "Your account has been temporarily locked",
+		Body: fmt.Sprintf(
+			"We blocked sign-ins to your account after %d failed attempts, the last one from %s. "+
+				"You can sign in again after %s. If this was not you, consider changing your password.",
+			s.policy.MaxAccountFailures, meta.IP, until.UTC().Format(time.RFC1123),
+		),
+	}
+	go func() {
+		if err := s.notifier.Notify(msg); err != nil {
+			log.Printf("lockout notification for user %d: %v", user.ID, err)
+		}
+	}()
+}
+
+type loginLockAudit struct {
+	Scope   string    `json:"scope"`
+	Subject string    `json:"subject"`
+	Until   time.Time `json:"until"`
+}
+
+func throttleSubjects(email string, user *models.User, ip string) map[string]string {
+	return map[string]string{
+		models.LoginThrottleAccount: accountThrottleSubject(email, user),
+		models.LoginThrottleIP:      ip,
+	}
+}
+
+// accountThrottleSubject keys the failures of an account on the account
+// itself, so that all aliases of its address share one counter. Addresses
+// without an account are keyed on their normalised form for the same reason.
+func accountThrottleSubject(email string, user *models.User) string {
+	if user != nil {
+		return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	return "email:" + emailpolicy.Normalize(email)
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/notify"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"github.com/serlenario/referral-system/internal/utils"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidMagicLink = errors.New("login link is invalid, expired or already used")
+
+type MagicLinkService interface {
+	SendLoginLink(email string, meta models.RequestMeta) error
+	RegisterWithReferral(referralCode, email, termsVersion string, meta models.RequestMeta) (*models.User, error)
+	Verify(token string, meta models.RequestMeta) (string, error)
+}
+
+type magicLinkService struct {
+	magicLinkRepo repositories.MagicLinkRepository
+	userRepo      repositories.UserRepository
+	userService   UserService
+	auditService  AuditService
+	notifier      notify.Notifier
+	linkURL       string
+	ttl           time.Duration
+	jwtSecret     string
+}
+
+func NewMagicLinkService(magicLinkRepo repositories.MagicLinkRepository, userRepo repositories.UserRepository, userService UserService, auditService AuditService, notifier notify.Notifier, linkURL string, ttl time.Duration, jwtSecret string) MagicLinkService {
+	return &magicLinkService{
+		magicLinkRepo: magicLinkRepo,
+		userRepo:      userRepo,
+		userService:   userService,
+		auditService:  auditService,
+		notifier:      notifier,
+		linkURL:       linkURL,
+		ttl:           ttl,
+		jwtSecret:     jwtSecret,
+	}
+}
+
+// SendLoginLink emails a login link if the address belongs to an active
+// account. Callers get no indication either way, so the endpoint cannot be
+// used to find out who is registered: the link is stored and sent in the
+// background, so the response takes as long for unknown addresses.
+func (s *magicLinkService) SendLoginLink(email string, meta models.RequestMeta) error {
+	user, err := s.userRepo.GetByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.IsActive(time.Now()) {
+		return nil
+	}
+
+	go func() {
+		if err := s.send(user, meta, "Your login link", "Use this link to log in"); err != nil {
+			log.Printf("magic link for user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// RegisterWithReferral creates an account without a password and sends a link
+// to log in with. Following the link also confirms the email address.
+func (s *magicLinkService) RegisterWithReferral(referralCode, email, termsVersion string, meta models.RequestMeta) (*models.User, error) {
+	user, err := s.userService.RegisterPasswordless(email, referralCode, termsVersion, false, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// The account exists either way; the user can ask for a new link.
+	if err := s.send(user, meta, "Finish signing up", "Use this link to log in to your new account"); err != nil {
+		log.Printf("magic link for user %d: %v", user.ID, err)
+	}
+	return user, nil
+}
+
+// Verify redeems a login link and returns an access token.
+func (s *magicLinkService) Verify(token string, meta models.RequestMeta) (string, error) {
+	claims, err := utils.ParseMagicLinkJWT(token, s.jwtSecret)
+	if err != nil {
+		return "", ErrInvalidMagicLink
+	}
+
+	consumed, err := s.magicLinkRepo.Consume(claims.ID, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if !consumed {
+		return "", ErrInvalidMagicLink
+	}
+
+	user, err := s.userRepo.GetByID(claims.UserID)
+	if err != nil {
+		return "", ErrInvalidMagicLink
+	}
+
+	// Only the owner of the mailbox could have followed the link.
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		if err := s.userRepo.MarkEmailVerified(user.ID, now); err != nil {
+			return "", err
+		}
+		user.EmailVerifiedAt = &now
+	}
+
+	return s.userService.IssueToken(user, LoginMethodMagicLink, meta)
+}
+
+// send stores a new link and emails it. Delivery happens in the background so
+// that response times do not reveal whether the address has an account.
+func (s *magicLinkService) send(user *models.User, meta models.RequestMeta, subject, intro string) error {
+	link := &models.MagicLink{
+		JTI:       uuid.New().String(),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	token, err := utils.GenerateMagicLinkJWT(user.ID, link.JTI, link.ExpiresAt, s.jwtSecret)
+	if err != nil {
+		return err
+	}
+
+	linkURL, err := linkWithToken(s.linkURL, token)
+	if err != nil {
+		return err
+	}
+
+	if err := s.magicLinkRepo.Create(link); err != nil {
+		return err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionMagicLinkSent,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		After:      magicLinkAudit{ExpiresAt: link.ExpiresAt},
+	})
+
+	msg := notify.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("%s:\n\n%s\n\nThe link works once and expires in %s. If you did not ask for it, you can ignore this email.",
+			intro, linkURL, s.ttl.Round(time.Minute)),
+	}
+	go func() {
+		if err := s.notifier.Notify(msg); err != nil {
+			log.Printf("magic link for user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// linkWithToken adds the token to a link sent by email.
+func linkWithToken(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+type magicLinkAudit struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
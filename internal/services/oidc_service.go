@@ -0,0 +1,253 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/oidc"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"gorm.io/gorm"
+)
+
+// oauthStateTTL is how long a user has to finish logging in at the provider.
+const oauthStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownProvider      = errors.New("unknown identity provider")
+	ErrInvalidOAuthState    = errors.New("login session is invalid or has expired, please start again")
+	ErrIdentityEmailMissing = errors.New("identity provider did not share an email address")
+	ErrIdentityEmailTaken   = errors.New("an account with this email already exists and the provider has not verified the address")
+)
+
+type OIDCLoginResult struct {
+	Token   string
+	User    *models.User
+	Created bool
+}
+
+type OIDCService interface {
+	Providers() []string
+	StartLogin(provider, referralCode, termsVersion string) (string, error)
+	CompleteLogin(provider, state, code string, meta models.RequestMeta) (*OIDCLoginResult, error)
+}
+
+type oidcService struct {
+	providers       map[string]*oidc.Provider
+	identityRepo    repositories.IdentityRepository
+	userRepo        repositories.UserRepository
+	apiKeyRepo      repositories.APIKeyRepository
+	emailChangeRepo repositories.EmailChangeRepository
+	userService     UserService
+	sessions        SessionService
+	auditService    AuditService
+}
+
+func NewOIDCService(providers []*oidc.Provider, identityRepo repositories.IdentityRepository, userRepo repositories.UserRepository, apiKeyRepo repositories.APIKeyRepository, emailChangeRepo repositories.EmailChangeRepository, userService UserService, sessions SessionService, auditService AuditService) OIDCService {
+	byName := make(map[string]*oidc.Provider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+
+	return &oidcService{
+		providers:       byName,
+		identityRepo:    identityRepo,
+		userRepo:        userRepo,
+		apiKeyRepo:      apiKeyRepo,
+		emailChangeRepo: emailChangeRepo,
+		userService:     userService,
+		sessions:        sessions,
+		auditService:    auditService,
+	}
+}
+
+func (s *oidcService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartLogin returns the provider URL to send the user to. The referral code
+// and accepted terms version are only used if the login ends up creating a
+// new account, but are checked now, while the user can still correct them.
+func (s *oidcService) StartLogin(providerName, referralCode, termsVersion string) (string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	if err := s.userService.CheckSignup(referralCode, termsVersion); err != nil {
+		return "", err
+	}
+
+	state := &models.OAuthState{
+		Provider:     providerName,
+		ReferralCode: referralCode,
+		TermsVersion: termsVersion,
+		ExpiresAt:    time.Now().Add(oauthStateTTL),
+	}
+	for _, value := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		random, err := oidc.RandomString(32)
+		if err != nil {
+			return "", err
+		}
+		*value = random
+	}
+
+	authURL, err := provider.AuthCodeURL(state.State, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.identityRepo.CreateState(state); err != nil {
+		return "", err
+	}
+	return authURL, nil
+}
+
+// CompleteLogin handles the provider's callback. The identity is matched to a
+// linked account first, then to an account with the same verified email;
+// otherwise a new account is created.
+func (s *oidcService) CompleteLogin(providerName, stateValue, code string, meta models.RequestMeta) (*OIDCLoginResult, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	state, err := s.identityRepo.ConsumeState(stateValue)
+	if err != nil || state.Provider != providerName || time.Now().After(state.ExpiresAt) {
+		return nil, ErrInvalidOAuthState
+	}
+
+	claims, err := provider.Exchange(code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &OIDCLoginResult{}
+	identity, err := s.identityRepo.Get(providerName, claims.Subject)
+	switch {
+	case err == nil:
+		result.User, err = s.userRepo.GetByID(identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		result.User, result.Created, err = s.linkIdentity(providerName, claims, state, meta)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	result.Token, err = s.userService.IssueToken(result.User, "oidc:"+providerName, meta)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *oidcService) linkIdentity(providerName string, claims *oidc.Claims, state *models.OAuthState, meta models.RequestMeta) (*models.User, bool, error) {
+	if claims.Email == "" {
+		return nil, false, ErrIdentityEmailMissing
+	}
+
+	created, reclaimed := false, false
+	user, err := s.userRepo.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// Linking to an existing account on an unverified address would let
+		// anyone take over an account by registering its email elsewhere.
+		if !claims.Verified() {
+			return nil, false, ErrIdentityEmailTaken
+		}
+		if user.EmailVerifiedAt == nil {
+			if err := s.reclaim(user); err != nil {
+				return nil, false, err
+			}
+			reclaimed = true
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		user, err = s.userService.RegisterPasswordless(claims.Email, state.ReferralCode, state.TermsVersion, claims.Verified(), meta)
+		if state.ReferralCode != "" && isReferralError(err) {
+			// The code was fine when the login started. The state is used
+			// up by now, so rather than making the user start over, sign
+			// them up without it.
+			user, err = s.userService.RegisterPasswordless(claims.Email, "", state.TermsVersion, claims.Verified(), meta)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		created = true
+	default:
+		return nil, false, err
+	}
+
+	identity := &models.UserIdentity{
+		UserID:   user.ID,
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := s.identityRepo.Create(identity); err != nil {
+		return nil, false, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionIdentityLinked,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		After:      identityAudit{Provider: providerName, Subject: claims.Subject, Email: claims.Email, Reclaimed: reclaimed},
+	})
+
+	return user, created, nil
+}
+
+// reclaim hands an account whose address was never verified to the owner of
+// the address. Whoever registered it may not have been that owner, so the
+// password, sessions, API keys, linked identities and pending email change
+// they set up stop working before the address is marked verified.
+func (s *oidcService) reclaim(user *models.User) error {
+	now := time.Now()
+	if err := s.userRepo.Reclaim(user.ID, now); err != nil {
+		return err
+	}
+	user.PasswordHash = ""
+	user.EmailVerifiedAt = &now
+
+	if err := s.sessions.RevokeOthers(user.ID, 0); err != nil {
+		return err
+	}
+	if err := s.apiKeyRepo.RevokeAllForUser(user.ID, now); err != nil {
+		return err
+	}
+	if err := s.identityRepo.DeleteForUser(user.ID); err != nil {
+		return err
+	}
+	return s.emailChangeRepo.DeleteForUser(user.ID)
+}
+
+// isReferralError reports whether a signup failed only because of its
+// referral code.
+func isReferralError(err error) bool {
+	for _, target := range []error{ErrInvalidReferralCode, ErrCampaignNotStarted, ErrCampaignEnded, ErrCampaignFull, ErrNotInCampaignAudience, ErrEmailDomainNotAllowed} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+type identityAudit struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+	// Reclaimed is set when the account's credentials were reset because
+	// its address had not been verified before.
+	Reclaimed bool `json:"reclaimed,omitempty"`
+}
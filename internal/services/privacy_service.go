@@ -0,0 +1,272 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/notify"
+	"github.com/serlenario/referral-system/internal/repositories"
+)
+
+// purgeBatchSize bounds how many accounts one purge run erases.
+const purgeBatchSize = 100
+
+type PrivacyService interface {
+	Export(userID uint, meta models.RequestMeta) (*models.AccountExport, error)
+	RequestDeletion(userID, sessionID uint, currentPassword string, meta models.RequestMeta) (*models.User, error)
+	PurgeDueAccounts() (int, error)
+}
+
+type privacyService struct {
+	userRepo          repositories.UserRepository
+	referralRepo      repositories.ReferralRepository
+	rewardRepo        repositories.RewardRepository
+	promoCodeRepo     repositories.PromoCodeRepository
+	auditRepo         repositories.AuditRepository
+	identityRepo      repositories.IdentityRepository
+	sessionRepo       repositories.SessionRepository
+	apiKeyRepo        repositories.APIKeyRepository
+	magicLinkRepo     repositories.MagicLinkRepository
+	emailChangeRepo   repositories.EmailChangeRepository
+	loginThrottleRepo repositories.LoginThrottleRepository
+	termsRepo         repositories.TermsRepository
+	accountService    AccountService
+	auditService      AuditService
+	notifier          notify.Notifier
+	grace             time.Duration
+}
+
+func NewPrivacyService(userRepo repositories.UserRepository, referralRepo repositories.ReferralRepository, rewardRepo repositories.RewardRepository, promoCodeRepo repositories.PromoCodeRepository, auditRepo repositories.AuditRepository, identityRepo repositories.IdentityRepository, sessionRepo repositories.SessionRepository, apiKeyRepo repositories.APIKeyRepository, magicLinkRepo repositories.MagicLinkRepository, emailChangeRepo repositories.EmailChangeRepository, loginThrottleRepo repositories.LoginThrottleRepository, termsRepo repositories.TermsRepository, accountService AccountService, auditService AuditService, notifier notify.Notifier, grace time.Duration) PrivacyService {
+	return &privacyService{
+		userRepo:          userRepo,
+		referralRepo:      referralRepo,
+		rewardRepo:        rewardRepo,
+		promoCodeRepo:     promoCodeRepo,
+		auditRepo:         auditRepo,
+		identityRepo:      identityRepo,
+		sessionRepo:       sessionRepo,
+		apiKeyRepo:        apiKeyRepo,
+		magicLinkRepo:     magicLinkRepo,
+		emailChangeRepo:   emailChangeRepo,
+		loginThrottleRepo: loginThrottleRepo,
+		termsRepo:         termsRepo,
+		accountService:    accountService,
+		auditService:      auditService,
+		notifier:          notifier,
+		grace:             grace,
+	}
+}
+
+// Export collects everything stored about the user.
+func (s *privacyService) Export(userID uint, meta models.RequestMeta) (*models.AccountExport, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &models.AccountExport{ExportedAt: time.Now().UTC(), Profile: user}
+
+	if export.Identities, err = s.identityRepo.ListForUser(userID); err != nil {
+		return nil, err
+	}
+
+	signup, err := s.referralRepo.List(repositories.ReferralFilter{ReferredID: userID, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(signup) > 0 {
+		export.SignupReferral = &signup[0]
+	}
+
+	export.Referrals = []models.Referral{}
+	err = s.referralRepo.Stream(repositories.ReferralFilter{ReferrerID: userID, Ascending: true}, exportBatchSize, func(referral *models.Referral) error {
+		export.Referrals = append(export.Referrals, *referral)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if export.Rewards, err = s.rewardRepo.ListForUser(userID); err != nil {
+		return nil, err
+	}
+	if export.PromoCodes, err = s.promoCodeRepo.ListByOwner(userID); err != nil {
+		return nil, err
+	}
+	if export.StatusHistory, err = s.userRepo.GetStatusChanges(userID); err != nil {
+		return nil, err
+	}
+	if export.Sessions, err = s.sessionRepo.ListActive(userID, time.Now()); err != nil {
+		return nil, err
+	}
+	if export.APIKeys, err = s.apiKeyRepo.ListForUser(userID); err != nil {
+		return nil, err
+	}
+	if export.AuditEntries, err = s.auditRepo.ListForUser(userID); err != nil {
+		return nil, err
+	}
+	if export.Terms, err = s.termsRepo.ListAcceptancesForUser(userID); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionDataExport,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+	})
+
+	return export, nil
+}
+
+// RequestDeletion logs the user out everywhere and schedules the account to
+// be erased after the grace period. Logging in before then cancels it.
+func (s *privacyService) RequestDeletion(userID, sessionID uint, currentPassword string, meta models.RequestMeta) (*models.User, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.accountService.VerifyPassword(user, sessionID, currentPassword, meta); err != nil {
+		return nil, err
+	}
+	if user.DeletionDueAt != nil {
+		return user, nil
+	}
+
+	now := time.Now()
+	dueAt := now.Add(s.grace)
+	user.DeletionDueAt = &dueAt
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	if err := s.sessionRepo.RevokeOthers(user.ID, 0, now); err != nil {
+		return nil, err
+	}
+	if err := s.apiKeyRepo.RevokeAllForUser(user.ID, now); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionDeletionRequest,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		After:      deletionAudit{DueAt: dueAt},
+	})
+
+	msg := notify.Message{
+		To:      user.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("As requested, your account and personal data will be deleted on %s. "+
+			"If you change your mind, just log in before then.", dueAt.UTC().Format(time.RFC1123)),
+	}
+	go func() {
+		if err := s.notifier.Notify(msg); err != nil {
+			log.Printf("deletion notice for user %d: %v", user.ID, err)
+		}
+	}()
+
+	return user, nil
+}
+
+// PurgeDueAccounts erases the accounts whose grace period has ended and
+// returns how many it erased.
+func (s *privacyService) PurgeDueAccounts() (int, error) {
+	users, err := s.userRepo.ListDueForDeletion(time.Now(), purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range users {
+		if err := s.erase(&users[i]); err != nil {
+			return purged, fmt.Errorf("erase user %d: %w", users[i].ID, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// erase removes the user's personal data, including the details of their
+// audit entries and the IP addresses and user agents of their sessions and
+// terms acceptances, and revokes the promo codes they own. The versions of
+// the terms they accepted and when are kept as the record of their consent. The row itself stays,
+// under a placeholder address, because referrals and rewards point at it and
+// referrers need them for their accounting. The user row is written last, so
+// a failed run is retried on the next one.
+func (s *privacyService) erase(user *models.User) error {
+	now := time.Now()
+
+	if err := s.identityRepo.DeleteForUser(user.ID); err != nil {
+		return err
+	}
+	if err := s.magicLinkRepo.DeleteForUser(user.ID); err != nil {
+		return err
+	}
+	if err := s.emailChangeRepo.DeleteForUser(user.ID); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.RevokeOthers(user.ID, 0, now); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.RedactForUser(user.ID); err != nil {
+		return err
+	}
+	if err := s.termsRepo.RedactAcceptances(user.ID); err != nil {
+		return err
+	}
+	if err := s.apiKeyRepo.RevokeAllForUser(user.ID, now); err != nil {
+		return err
+	}
+	if _, err := s.promoCodeRepo.RevokeByOwner(user.ID); err != nil {
+		return err
+	}
+	if err := s.loginThrottleRepo.Reset(models.LoginThrottleAccount, accountThrottleSubject("", user)); err != nil {
+		return err
+	}
+	if _, err := s.auditRepo.RedactForUser(user.ID, now); err != nil {
+		return err
+	}
+
+	user.Email = fmt.Sprintf("deleted-%d@deleted.invalid", user.ID)
+	user.PasswordHash = ""
+	clearReferralCode(user)
+	user.ReferralCodePublic = false
+	user.DisplayName = ""
+	user.LeaderboardOptOut = true
+	user.EmailVerifiedAt = nil
+	user.Status = models.UserStatusDeleted
+	user.StatusReason = ""
+	user.StatusExpiresAt = nil
+	user.StatusChangedBy = &user.ID
+	user.StatusChangedAt = &now
+	user.DeletionDueAt = nil
+	if err := s.userRepo.Update(user); err != nil {
+		return err
+	}
+
+	err := s.userRepo.CreateStatusChange(&models.UserStatusChange{
+		UserID:    user.ID,
+		Status:    models.UserStatusDeleted,
+		Reason:    "deleted at the user's request",
+		ChangedBy: user.ID,
+	})
+	if err != nil {
+		log.Printf("status history of deleted user %d: %v", user.ID, err)
+	}
+
+	s.auditService.Record(AuditEvent{
+		Action:     models.AuditActionDeletionComplete,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+	})
+	return nil
+}
+
+type deletionAudit struct {
+	DueAt time.Time `json:"due_at"`
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"github.com/serlenario/referral-system/internal/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionEnded    = errors.New("session has ended")
+)
+
+type SessionService interface {
+	Start(user *models.User, method string, meta models.RequestMeta) (*models.Session, error)
+	Validate(sessionID, userID uint) error
+	List(userID, currentSessionID uint) ([]models.Session, error)
+	Revoke(userID, sessionID uint, meta models.RequestMeta) error
+	RevokeOthers(userID, keepSessionID uint) error
+	Fresh(userID, sessionID uint, maxAge time.Duration) (bool, error)
+}
+
+type sessionService struct {
+	sessionRepo  repositories.SessionRepository
+	auditService AuditService
+}
+
+func NewSessionService(sessionRepo repositories.SessionRepository, auditService AuditService) SessionService {
+	return &sessionService{
+		sessionRepo:  sessionRepo,
+		auditService: auditService,
+	}
+}
+
+// Start records a new login. The session lasts as long as the token issued
+// for it.
+func (s *sessionService) Start(user *models.User, method string, meta models.RequestMeta) (*models.Session, error) {
+	now := time.Now()
+	session := &models.Session{
+		UserID:      user.ID,
+		Device:      utils.DeviceName(meta.UserAgent),
+		UserAgent:   meta.UserAgent,
+		IP:          meta.IP,
+		LoginMethod: method,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(utils.TokenTTL),
+	}
+	if err := s.sessionRepo.Create(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Validate checks that a token's session still exists, belongs to the user
+// and has not been ended, and records the activity.
+func (s *sessionService) Validate(sessionID, userID uint) error {
+	if sessionID == 0 {
+		return ErrSessionEnded
+	}
+
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionEnded
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if session.UserID != userID || !session.Active(now) {
+		return ErrSessionEnded
+	}
+
+	if err := s.sessionRepo.TouchLastSeen(session.ID, now); err != nil {
+		log.Printf("session %d: %v", session.ID, err)
+	}
+	return nil
+}
+
+func (s *sessionService) List(userID, currentSessionID uint) ([]models.Session, error) {
+	sessions, err := s.sessionRepo.ListActive(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+	return sessions, nil
+}
+
+// Revoke ends one of the user's sessions; its tokens are rejected from then on.
+func (s *sessionService) Revoke(userID, sessionID uint, meta models.RequestMeta) error {
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID || !session.Active(time.Now()) {
+		return ErrSessionNotFound
+	}
+
+	if err := s.sessionRepo.Revoke(session.ID, time.Now()); err != nil {
+		return err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionSessionRevoked,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &userID,
+		Before:     sessionAudit{SessionID: session.ID, Device: session.Device, IP: session.IP},
+	})
+	return nil
+}
+
+// RevokeOthers logs the user out everywhere except the given session, e.g.
+// after a password change. Callers record the reason in the audit log.
+func (s *sessionService) RevokeOthers(userID, keepSessionID uint) error {
+	return s.sessionRepo.RevokeOthers(userID, keepSessionID, time.Now())
+}
+
+// Fresh reports whether the session is the user's, still active and was
+// started less than maxAge ago, i.e. the user has just logged in.
+func (s *sessionService) Fresh(userID, sessionID uint, maxAge time.Duration) (bool, error) {
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	return session.UserID == userID && session.Active(now) && now.Sub(session.CreatedAt) < maxAge, nil
+}
+
+type sessionAudit struct {
+	SessionID uint   `json:"session_id"`
+	Device    string `json:"device"`
+	IP        string `json:"ip"`
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/repositories"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNoTerms           = errors.New("no terms have been published")
+	ErrTermsOutdated     = errors.New("terms version is not the current one")
+	ErrTermsRequired     = errors.New("the current terms version has to be accepted")
+	ErrTermsVersionTaken = errors.New("terms version already exists")
+)
+
+type TermsInput struct {
+	Version string
+	URL     string
+	Summary string
+}
+
+// TermsStatus tells a user which terms are current and whether they have
+// accepted them.
+type TermsStatus struct {
+	Current  *models.TermsVersion    `json:"current"`
+	Accepted *models.TermsAcceptance `json:"accepted,omitempty"`
+	UpToDate bool                    `json:"up_to_date"`
+}
+
+type TermsService interface {
+	Current() (*models.TermsVersion, error)
+	List() ([]models.TermsVersion, error)
+	Publish(input TermsInput, meta models.RequestMeta) (*models.TermsVersion, error)
+	Status(userID uint) (*TermsStatus, error)
+	Accept(userID uint, version string, meta models.RequestMeta) (*TermsStatus, error)
+	CheckVersion(version string) (*models.TermsVersion, error)
+	NewAcceptance(terms *models.TermsVersion, meta models.RequestMeta) *models.TermsAcceptance
+	AuditAcceptance(acceptance *models.TermsAcceptance, meta models.RequestMeta)
+	HasAcceptedCurrent(userID uint) (bool, error)
+}
+
+type termsService struct {
+	termsRepo    repositories.TermsRepository
+	rewardRepo   repositories.RewardRepository
+	auditService AuditService
+}
+
+func NewTermsService(termsRepo repositories.TermsRepository, rewardRepo repositories.RewardRepository, auditService AuditService) TermsService {
+	return &termsService{
+		termsRepo:    termsRepo,
+		rewardRepo:   rewardRepo,
+		auditService: auditService,
+	}
+}
+
+func (s *termsService) Current() (*models.TermsVersion, error) {
+	terms, err := s.termsRepo.GetCurrent()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoTerms
+	}
+	return terms, err
+}
+
+func (s *termsService) List() ([]models.TermsVersion, error) {
+	return s.termsRepo.ListVersions()
+}
+
+// Publish makes a new version current. Users who accepted an older version
+// get no further rewards until they accept this one.
+func (s *termsService) Publish(input TermsInput, meta models.RequestMeta) (*models.TermsVersion, error) {
+	version := strings.TrimSpace(input.Version)
+	_, err := s.termsRepo.GetVersion(version)
+	switch {
+	case err == nil:
+		return nil, ErrTermsVersionTaken
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	terms := &models.TermsVersion{
+		Version: version,
+		URL:     input.URL,
+		Summary: input.Summary,
+	}
+	if err := s.termsRepo.CreateVersion(terms); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionTermsPublish,
+		TargetType: models.AuditTargetTerms,
+		TargetID:   &terms.ID,
+		After:      terms,
+	})
+	return terms, nil
+}
+
+func (s *termsService) Status(userID uint) (*TermsStatus, error) {
+	current, err := s.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &TermsStatus{Current: current}
+	accepted, err := s.termsRepo.GetLatestAcceptance(userID)
+	switch {
+	case err == nil:
+		status.Accepted = accepted
+		status.UpToDate = accepted.Version == current.Version
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	return status, nil
+}
+
+// Accept records that the user accepted the given version, which has to be
+// the current one, and grants the rewards held back until then.
+func (s *termsService) Accept(userID uint, version string, meta models.RequestMeta) (*TermsStatus, error) {
+	terms, err := s.CheckVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if terms == nil {
+		return nil, ErrNoTerms
+	}
+
+	acceptance := s.NewAcceptance(terms, meta)
+	acceptance.UserID = userID
+	if err := s.termsRepo.CreateAcceptance(acceptance); err != nil {
+		return nil, err
+	}
+	s.AuditAcceptance(acceptance, meta)
+
+	granted, err := s.rewardRepo.GrantPending(userID)
+	if err != nil {
+		return nil, err
+	}
+	if granted > 0 {
+		log.Printf("granted %d pending rewards to user %d after accepting terms %s", granted, userID, terms.Version)
+	}
+
+	return s.Status(userID)
+}
+
+// CheckVersion validates the terms version a client says the user accepted.
+// It returns nil for an empty version while no terms are published,
+// ErrTermsRequired for an empty version once they are, and ErrTermsOutdated
+// for anything but the current version.
+func (s *termsService) CheckVersion(version string) (*models.TermsVersion, error) {
+	current, err := s.Current()
+	if errors.Is(err, ErrNoTerms) {
+		if version == "" {
+			return nil, nil
+		}
+		return nil, ErrTermsOutdated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		return nil, ErrTermsRequired
+	}
+	if current.Version != version {
+		return nil, ErrTermsOutdated
+	}
+	return current, nil
+}
+
+// NewAcceptance prepares the acceptance of terms returned by CheckVersion,
+// with the IP and user agent it came from, for the caller to store. It
+// returns nil if terms is nil.
+func (s *termsService) NewAcceptance(terms *models.TermsVersion, meta models.RequestMeta) *models.TermsAcceptance {
+	if terms == nil {
+		return nil
+	}
+	return &models.TermsAcceptance{
+		Version:    terms.Version,
+		IP:         meta.IP,
+		UserAgent:  meta.UserAgent,
+		AcceptedAt: time.Now(),
+	}
+}
+
+// AuditAcceptance records a stored acceptance in the audit log.
+func (s *termsService) AuditAcceptance(acceptance *models.TermsAcceptance, meta models.RequestMeta) {
+	meta.ActorID = &acceptance.UserID
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionTermsAccept,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &acceptance.UserID,
+		After:      termsAudit{Version: acceptance.Version},
+	})
+}
+
+// HasAcceptedCurrent reports whether the user may be rewarded. While no terms
+// are published, everyone may.
+func (s *termsService) HasAcceptedCurrent(userID uint) (bool, error) {
+	current, err := s.Current()
+	if errors.Is(err, ErrNoTerms) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.termsRepo.GetAcceptance(userID, current.Version)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+type termsAudit struct {
+	Version string `json:"version"`
+}
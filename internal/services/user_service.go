@@ -2,146 +2,869 @@ package services
 
 import (
 	"errors"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/serlenario/referral-system/internal/emailpolicy"
 	"github.com/serlenario/referral-system/internal/models"
+	"github.com/serlenario/referral-system/internal/password"
 	"github.com/serlenario/referral-system/internal/repositories"
 	"github.com/serlenario/referral-system/internal/utils"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserService interface {
-	Register(email, password string) (*models.User, error)
-	Authenticate(email, password string) (string, error)
-	CreateReferralCode(userID uint, expiry time.Time) (*models.User, error)
-	DeleteReferralCode(userID uint) (*models.User, error)
+	Register(email, password, termsVersion string, meta models.RequestMeta) (*models.User, error)
+	Authenticate(email, password string, meta models.RequestMeta) (string, error)
+	IssueToken(user *models.User, method string, meta models.RequestMeta) (string, error)
+	CreateReferralCode(userID uint, expiry time.Time, campaignID *uint, meta models.RequestMeta) (*models.User, error)
+	DeleteReferralCode(userID uint, meta models.RequestMeta) (*models.User, error)
 	GetReferralCodeByEmail(email string) (string, error)
-	RegisterWithReferral(referralCode, email, password string) (*models.User, error)
-	GetReferrals(userID uint) ([]models.Referral, error)
+	GetOwnReferralCode(userID uint) (*models.User, error)
+	SetReferralCodeVisibility(userID uint, public bool, meta models.RequestMeta) (*models.User, error)
+	RegisterWithReferral(referralCode, email, password, termsVersion string, meta models.RequestMeta) (*models.User, error)
+	RegisterPasswordless(email, referralCode, termsVersion string, emailVerified bool, meta models.RequestMeta) (*models.User, error)
+	CheckSignup(referralCode, termsVersion string) error
+	ApproveReferral(referralID uint, meta models.RequestMeta) (*models.Referral, error)
+	GetReferrals(userID uint, opts ReferralListOptions) (*ReferralPage, error)
+}
+
+// Login methods recorded in the audit log.
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodMagicLink = "magic_link"
+)
+
+const (
+	ReferralSortCreatedAtDesc = "created_at_desc"
+	ReferralSortCreatedAtAsc  = "created_at_asc"
+)
+
+var (
+	ErrAccountInactive     = errors.New("account is suspended or banned")
+	ErrInvalidReferralCode = errors.New("invalid referral code")
+	ErrNoReferralCode      = errors.New("referral code not found")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrInvalidReferralSort = errors.New("invalid sort order")
+	ErrReferralNotPending  = errors.New("referral is not pending review")
+)
+
+type ReferralListOptions struct {
+	From       time.Time
+	To         time.Time
+	Status     string
+	Code       string
+	CampaignID uint
+	Sort       string
+	Cursor     string
+	Limit      int
+}
+
+type ReferralPage struct {
+	Referrals  []models.ReferralDetails
+	NextCursor string
+	Total      int64
 }
 
 type userService struct {
-	userRepo     repositories.UserRepository
-	referralRepo repositories.ReferralRepository
-	jwtSecret    string
+	userRepo      repositories.UserRepository
+	referralRepo  repositories.ReferralRepository
+	promoCodeRepo repositories.PromoCodeRepository
+	campaignRepo  repositories.CampaignRepository
+	auditService  AuditService
+	loginThrottle LoginThrottleService
+	sessions      SessionService
+	terms         TermsService
+	passwords     *password.Validator
+	hasher        *password.MultiHasher
+	emailPolicy   *emailpolicy.Policy
+	emailMasker   utils.EmailMasker
+	jwtSecret     string
 }
 
-func NewUserService(userRepo repositories.UserRepository, referralRepo repositories.ReferralRepository, jwtSecret string) UserService {
+func NewUserService(userRepo repositories.UserRepository, referralRepo repositories.ReferralRepository, promoCodeRepo repositories.PromoCodeRepository, campaignRepo repositories.CampaignRepository, auditService AuditService, loginThrottle LoginThrottleService, sessions SessionService, terms TermsService, passwords *password.Validator, hasher *password.MultiHasher, emailPolicy *emailpolicy.Policy, emailMasker utils.EmailMasker, jwtSecret string) UserService {
 	return &userService{
-		userRepo:     userRepo,
-		referralRepo: referralRepo,
-		jwtSecret:    jwtSecret,
+		userRepo:      userRepo,
+		referralRepo:  referralRepo,
+		promoCodeRepo: promoCodeRepo,
+		campaignRepo:  campaignRepo,
+		auditService:  auditService,
+		loginThrottle: loginThrottle,
+		sessions:      sessions,
+		terms:         terms,
+		passwords:     passwords,
+		hasher:        hasher,
+		emailPolicy:   emailPolicy,
+		emailMasker:   emailMasker,
+		jwtSecret:     jwtSecret,
 	}
 }
 
-func (s *userService) Register(email, password string) (*models.User, error) {
-	existingUser, _ := s.userRepo.GetByEmail(email)
-	if existingUser != nil {
-		return nil, errors.New("email already registered")
+// Register creates an account with a password. termsVersion is the version
+// of the programme terms the user accepted while signing up; it is required
+// once terms are published.
+func (s *userService) Register(email, password, termsVersion string, meta models.RequestMeta) (*models.User, error) {
+	terms, err := s.terms.CheckVersion(termsVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := s.hashNewPassword(password, email)
+	if err != nil {
+		return nil, err
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return s.register(email, passwordHash, false, terms, meta)
+}
+
+// RegisterPasswordless creates an account for a user who proved control of
+// the email address some other way, such as an identity provider. With a
+// referral code the signup is attributed like RegisterWithReferral.
+func (s *userService) RegisterPasswordless(email, referralCode, termsVersion string, emailVerified bool, meta models.RequestMeta) (*models.User, error) {
+	terms, err := s.terms.CheckVersion(termsVersion)
 	if err != nil {
 		return nil, err
 	}
 
+	if referralCode != "" {
+		return s.registerWithReferral(referralCode, email, "", emailVerified, terms, meta)
+	}
+	return s.register(email, "", emailVerified, terms, meta)
+}
+
+// CheckSignup reports early whether a referral code and terms version given
+// for a signup that only happens later would be accepted. Either may be
+// empty, since the signup may turn out to be a login; a missing terms version
+// and rules depending on the email address are checked at signup.
+func (s *userService) CheckSignup(referralCode, termsVersion string) error {
+	if termsVersion != "" {
+		if _, err := s.terms.CheckVersion(termsVersion); err != nil {
+			return err
+		}
+	}
+	if referralCode == "" {
+		return nil
+	}
+
+	source, err := s.resolveReferralCode(referralCode)
+	if err != nil {
+		return err
+	}
+	campaign, err := s.checkCampaign(source)
+	if err != nil || campaign == nil || campaign.MaxParticipants == 0 {
+		return err
+	}
+
+	participants, err := s.campaignRepo.CountReferrals(campaign.ID)
+	if err != nil {
+		return err
+	}
+	if participants >= int64(campaign.MaxParticipants) {
+		return ErrCampaignFull
+	}
+	return nil
+}
+
+func (s *userService) hashNewPassword(password, email string) (string, error) {
+	if err := s.passwords.Validate(password, email); err != nil {
+		return "", err
+	}
+	return s.hasher.Hash(password)
+}
+
+// register stores a new user together with their acceptance of terms, if
+// any. An empty passwordHash creates an account that cannot log in with a
+// password.
+func (s *userService) register(email, passwordHash string, emailVerified bool, terms *models.TermsVersion, meta models.RequestMeta) (*models.User, error) {
+	user, err := s.newUser(email, passwordHash, emailVerified)
+	if err != nil {
+		return nil, err
+	}
+
+	signup := &repositories.Signup{User: user, Acceptance: s.terms.NewAcceptance(terms, meta)}
+	if err := s.userRepo.CreateSignup(signup); err != nil {
+		return nil, err
+	}
+
+	s.recordRegistration(signup, meta)
+	return user, nil
+}
+
+// newUser prepares a new user without storing it. Addresses are checked
+// against the email policy, and aliases of a registered address count as
+// taken.
+func (s *userService) newUser(email, passwordHash string, emailVerified bool) (*models.User, error) {
+	flag, err := s.emailPolicy.Check(email)
+	if err != nil {
+		return nil, err
+	}
+
+	existingUser, _ := s.userRepo.GetByEmail(email)
+	if existingUser != nil {
+		return nil, ErrEmailTaken
+	}
+
 	user := &models.User{
 		Email:        email,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: passwordHash,
+		Role:         models.RoleUser,
+		Status:       models.UserStatusActive,
+		EmailFlag:    flag,
 	}
+	if emailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	return user, nil
+}
 
-	return user, s.userRepo.Create(user)
+// recordRegistration audits a stored signup and the terms accepted with it.
+func (s *userService) recordRegistration(signup *repositories.Signup, meta models.RequestMeta) {
+	user := signup.User
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionRegister,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		After:      userAudit{Email: user.Email, Role: user.Role, EmailFlag: user.EmailFlag},
+	})
+
+	if signup.Acceptance != nil {
+		s.terms.AuditAcceptance(signup.Acceptance, meta)
+	}
 }
 
-func (s *userService) Authenticate(email, password string) (string, error) {
+func (s *userService) Authenticate(email, password string, meta models.RequestMeta) (string, error) {
+	// The account is looked up first, as its failures are counted on the
+	// account whichever alias of its address is typed.
 	user, err := s.userRepo.GetByEmail(email)
 	if err != nil {
+		user = nil
+	}
+	if err := s.loginThrottle.Check(email, user, meta.IP); err != nil {
+		return "", err
+	}
+
+	if user == nil {
+		s.recordLoginFailure(meta, nil, email, "unknown email")
+		s.loginThrottle.RecordFailure(email, nil, meta)
 		return "", errors.New("invalid credentials")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+	// Accounts created through an identity provider have no password.
+	var match, rehash bool
+	if user.PasswordHash != "" {
+		match, rehash, err = s.hasher.Verify(user.PasswordHash, password)
+		if err != nil {
+			log.Printf("verify password of user %d: %v", user.ID, err)
+		}
+	}
+	if !match {
+		s.recordLoginFailure(meta, &user.ID, email, "wrong password")
+		s.loginThrottle.RecordFailure(email, user, meta)
 		return "", errors.New("invalid credentials")
 	}
 
-	token, err := utils.GenerateJWT(user.ID, s.jwtSecret)
+	s.loginThrottle.RecordSuccess(user)
+
+	if rehash {
+		s.upgradePasswordHash(user, password)
+	}
+
+	return s.IssueToken(user, LoginMethodPassword, meta)
+}
+
+// IssueToken completes a login once the user has been authenticated by
+// whatever method, refusing suspended and banned accounts.
+func (s *userService) IssueToken(user *models.User, method string, meta models.RequestMeta) (string, error) {
+	if !user.IsActive(time.Now()) {
+		s.recordLoginFailure(meta, &user.ID, user.Email, "account "+user.Status)
+		return "", ErrAccountInactive
+	}
+
+	if user.DeletionDueAt != nil {
+		if err := s.cancelDeletion(user, meta); err != nil {
+			return "", err
+		}
+	}
+
+	session, err := s.sessions.Start(user, method, meta)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := utils.GenerateJWT(user.ID, user.Role, session.ID, session.ExpiresAt, s.jwtSecret)
 	if err != nil {
 		return "", err
 	}
 
+	meta.ActorID = &user.ID
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionLoginSucceeded,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		After:      loginAudit{Method: method, SessionID: session.ID},
+	})
+
 	return token, nil
 }
 
-func (s *userService) CreateReferralCode(userID uint, expiry time.Time) (*models.User, error) {
+// cancelDeletion keeps an account the user asked to delete, because they
+// logged in again before the grace period ended.
+func (s *userService) cancelDeletion(user *models.User, meta models.RequestMeta) error {
+	dueAt := *user.DeletionDueAt
+	user.DeletionDueAt = nil
+	if err := s.userRepo.Update(user); err != nil {
+		return err
+	}
+
+	meta.ActorID = &user.ID
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionDeletionCancel,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     deletionAudit{DueAt: dueAt},
+	})
+	return nil
+}
+
+// upgradePasswordHash replaces a hash made with an outdated algorithm or
+// parameters while the plain password is at hand. Failing to do so does not
+// affect the login; it is simply tried again next time.
+func (s *userService) upgradePasswordHash(user *models.User, password string) {
+	hash, err := s.hasher.Hash(password)
+	if err == nil {
+		// A password changed since it was verified here is left as it is.
+		_, err = s.userRepo.UpdatePasswordHash(user.ID, user.PasswordHash, hash)
+	}
+	if err != nil {
+		log.Printf("upgrade password hash of user %d: %v", user.ID, err)
+	}
+}
+
+func (s *userService) recordLoginFailure(meta models.RequestMeta, userID *uint, email, reason string) {
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionLoginFailed,
+		TargetType: models.AuditTargetUser,
+		TargetID:   userID,
+		After:      loginFailureAudit{Email: email, Reason: reason},
+	})
+}
+
+func (s *userService) CreateReferralCode(userID uint, expiry time.Time, campaignID *uint, meta models.RequestMeta) (*models.User, error) {
 	user, err := s.userRepo.GetByID(userID)
 	if err != nil {
 		return nil, err
 	}
 
+	if campaignID != nil {
+		campaign, err := s.campaignRepo.GetByID(*campaignID)
+		if err != nil {
+			return nil, ErrCampaignNotFound
+		}
+		if campaign.Ended(time.Now()) {
+			return nil, ErrCampaignEnded
+		}
+	}
+
+	before := referralCodeState(user)
 	referralCode := uuid.New().String()
 
 	user.ReferralCode = referralCode
 	user.ReferralExpiry = expiry
+	user.ReferralCampaignID = campaignID
+
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionReferralCodeCreate,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     before,
+		After:      referralCodeState(user),
+	})
 
-	return user, s.userRepo.Update(user)
+	return user, nil
 }
 
-func (s *userService) DeleteReferralCode(userID uint) (*models.User, error) {
+func (s *userService) DeleteReferralCode(userID uint, meta models.RequestMeta) (*models.User, error) {
 	user, err := s.userRepo.GetByID(userID)
 	if err != nil {
 		return nil, err
 	}
 
+	before := referralCodeState(user)
+	clearReferralCode(user)
+
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionReferralCodeDelete,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     before,
+		After:      referralCodeState(user),
+	})
+
+	return user, nil
+}
+
+func clearReferralCode(user *models.User) {
 	user.ReferralCode = ""
 	user.ReferralExpiry = time.Time{}
+	user.ReferralCampaignID = nil
+}
+
+type userAudit struct {
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	EmailFlag string `json:"email_flag,omitempty"`
+}
+
+type loginAudit struct {
+	Method    string `json:"method"`
+	SessionID uint   `json:"session_id"`
+}
+
+type loginFailureAudit struct {
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
 
-	return user, s.userRepo.Update(user)
+type referralAudit struct {
+	ReferredBy   uint   `json:"referred_by"`
+	ReferredID   uint   `json:"referred_id"`
+	ReferralCode string `json:"referral_code"`
+	CampaignID   *uint  `json:"campaign_id,omitempty"`
+	Status       string `json:"status"`
 }
 
+type referralStatusAudit struct {
+	Status  string `json:"status"`
+	Rewards int    `json:"rewards,omitempty"`
+}
+
+type referralCodeVisibilityAudit struct {
+	Public bool `json:"public"`
+}
+
+// referralCodeAudit is the part of a user recorded when their referral code changes.
+type referralCodeAudit struct {
+	ReferralCode       string    `json:"referral_code,omitempty"`
+	ReferralExpiry     time.Time `json:"referral_expiry"`
+	ReferralCampaignID *uint     `json:"referral_campaign_id,omitempty"`
+}
+
+func referralCodeState(user *models.User) referralCodeAudit {
+	return referralCodeAudit{
+		ReferralCode:       user.ReferralCode,
+		ReferralExpiry:     user.ReferralExpiry,
+		ReferralCampaignID: user.ReferralCampaignID,
+	}
+}
+
+// GetReferralCodeByEmail returns the code of a user who made it public. Unknown
+// emails, private, missing and expired codes all give ErrNoReferralCode so
+// that the endpoint cannot be used to find out who is registered.
 func (s *userService) GetReferralCodeByEmail(email string) (string, error) {
 	user, err := s.userRepo.GetByEmail(email)
 	if err != nil {
-		return "", err
+		return "", ErrNoReferralCode
 	}
 
-	if !user.ReferralExpiry.IsZero() && user.ReferralExpiry.Before(time.Now()) {
-		return "", errors.New("referral code expired")
+	if !user.ReferralCodePublic || !user.CanRefer(time.Now()) || !hasValidReferralCode(user) {
+		return "", ErrNoReferralCode
+	}
+
+	return user.ReferralCode, nil
+}
+
+func (s *userService) GetOwnReferralCode(userID uint) (*models.User, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
 	}
 
 	if user.ReferralCode == "" {
-		return "", errors.New("no referral code found")
+		return nil, ErrNoReferralCode
 	}
 
-	return user.ReferralCode, nil
+	return user, nil
+}
+
+func (s *userService) SetReferralCodeVisibility(userID uint, public bool, meta models.RequestMeta) (*models.User, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	before := user.ReferralCodePublic
+	user.ReferralCodePublic = public
+
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionReferralCodePublic,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Before:     referralCodeVisibilityAudit{Public: before},
+		After:      referralCodeVisibilityAudit{Public: public},
+	})
+
+	return user, nil
 }
 
-func (s *userService) RegisterWithReferral(referralCode, email, password string) (*models.User, error) {
-	referrer, err := s.userRepo.GetByReferralCode(referralCode)
+func hasValidReferralCode(user *models.User) bool {
+	if user.ReferralCode == "" {
+		return false
+	}
+	return user.ReferralExpiry.IsZero() || user.ReferralExpiry.After(time.Now())
+}
+
+func (s *userService) RegisterWithReferral(referralCode, email, password, termsVersion string, meta models.RequestMeta) (*models.User, error) {
+	terms, err := s.terms.CheckVersion(termsVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := s.hashNewPassword(password, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.registerWithReferral(referralCode, email, passwordHash, false, terms, meta)
+}
+
+func (s *userService) registerWithReferral(referralCode, email, passwordHash string, emailVerified bool, terms *models.TermsVersion, meta models.RequestMeta) (*models.User, error) {
+	source, err := s.resolveReferralCode(referralCode)
+	if err != nil {
+		return nil, err
+	}
+
+	campaign, err := s.checkCampaign(source)
 	if err != nil {
-		return nil, errors.New("invalid referral code")
+		return nil, err
+	}
+	if err := checkCampaignDomain(campaign, email); err != nil {
+		return nil, err
 	}
 
-	newUser, err := s.Register(email, password)
+	newUser, err := s.newUser(email, passwordHash, emailVerified)
 	if err != nil {
 		return nil, err
 	}
 
+	// Referrals of flagged signups wait for review instead of counting
+	// towards leaderboards and rewards straight away.
 	referral := &models.Referral{
-		ReferredID: newUser.ID,
-		ReferredBy: referrer.ID,
+		ReferredBy:   source.referrer.ID,
+		ReferralCode: referralCode,
+		CampaignID:   source.campaignID,
+		Status:       models.ReferralStatusQualified,
+	}
+	if newUser.EmailFlag != "" {
+		referral.Status = models.ReferralStatusPending
+	}
+
+	signup := &repositories.Signup{User: newUser, Acceptance: s.terms.NewAcceptance(terms, meta), Referral: referral}
+	if source.promoCode != nil {
+		signup.PromoCodeID = &source.promoCode.ID
+	}
+	if campaign != nil {
+		signup.MaxParticipants = campaign.MaxParticipants
+		if referral.Status == models.ReferralStatusQualified {
+			refereeAccepted, err := s.acceptedAtSignup(terms)
+			if err != nil {
+				return nil, err
+			}
+			signup.Rewards, err = s.campaignRewards(campaign, referral, refereeAccepted)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	if err := s.referralRepo.Create(referral); err != nil {
+	switch err := s.userRepo.CreateSignup(signup); {
+	case errors.Is(err, repositories.ErrCampaignFull):
+		return nil, ErrCampaignFull
+	case errors.Is(err, repositories.ErrPromoCodeUnavailable):
+		return nil, ErrInvalidReferralCode
+	case err != nil:
 		return nil, err
 	}
 
+	s.recordRegistration(signup, meta)
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionReferralCreate,
+		TargetType: models.AuditTargetReferral,
+		TargetID:   &referral.ID,
+		After: referralAudit{
+			ReferredBy:   referral.ReferredBy,
+			ReferredID:   referral.ReferredID,
+			ReferralCode: referral.ReferralCode,
+			CampaignID:   referral.CampaignID,
+			Status:       referral.Status,
+		},
+	})
+
 	return newUser, nil
 }
 
-func (s *userService) GetReferrals(userID uint) ([]models.Referral, error) {
-	referrals, err := s.referralRepo.GetByReferrerID(userID)
+// referralSource describes who a referral code belongs to: either a user's
+// personal code or a promo code owned by that user.
+type referralSource struct {
+	referrer   *models.User
+	promoCode  *models.PromoCode
+	campaignID *uint
+}
+
+func (s *userService) resolveReferralCode(code string) (*referralSource, error) {
+	if referrer, err := s.userRepo.GetByReferralCode(code); err == nil && referrer.CanRefer(time.Now()) {
+		return &referralSource{referrer: referrer, campaignID: referrer.ReferralCampaignID}, nil
+	}
+
+	promoCode, err := s.promoCodeRepo.GetByCode(code)
+	if err != nil || !promoCode.Usable(time.Now()) {
+		return nil, ErrInvalidReferralCode
+	}
+
+	referrer, err := s.userRepo.GetByID(promoCode.OwnerID)
+	if err != nil || !referrer.CanRefer(time.Now()) {
+		return nil, ErrInvalidReferralCode
+	}
+
+	return &referralSource{referrer: referrer, promoCode: promoCode, campaignID: promoCode.CampaignID}, nil
+}
+
+// checkCampaign applies the rules of the campaign the code belongs to, if any.
+func (s *userService) checkCampaign(source *referralSource) (*models.Campaign, error) {
+	if source.campaignID == nil {
+		return nil, nil
+	}
+
+	campaign, err := s.campaignRepo.GetByID(*source.campaignID)
+	if err != nil {
+		return nil, ErrInvalidReferralCode
+	}
+
+	now := time.Now()
+	if !campaign.Started(now) {
+		return nil, ErrCampaignNotStarted
+	}
+	if campaign.Ended(now) {
+		return nil, ErrCampaignEnded
+	}
+
+	if campaign.TargetAudience == models.AudienceVerifiedReferrers && source.referrer.EmailVerifiedAt == nil {
+		return nil, ErrNotInCampaignAudience
+	}
+
+	// The participant limit is enforced when the signup is stored.
+
+	return campaign, nil
+}
+
+// checkCampaignDomain applies the email domain lists of a campaign, if any,
+// to the address signing up.
+func checkCampaignDomain(campaign *models.Campaign, email string) error {
+	if campaign == nil {
+		return nil
+	}
+
+	domain := emailpolicy.Domain(email)
+	if len(campaign.AllowedDomains) > 0 && !emailpolicy.MatchDomain(domain, campaign.AllowedDomains) {
+		return ErrEmailDomainNotAllowed
+	}
+	if emailpolicy.MatchDomain(domain, campaign.BlockedDomains) {
+		return ErrEmailDomainNotAllowed
+	}
+	return nil
+}
+
+// acceptedAtSignup reports whether a new user may be rewarded: they accepted
+// terms while signing up, where only the current version is accepted, or no
+// terms are published.
+func (s *userService) acceptedAtSignup(terms *models.TermsVersion) (bool, error) {
+	if terms != nil {
+		return true, nil
+	}
+	_, err := s.terms.Current()
+	switch {
+	case errors.Is(err, ErrNoTerms):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	return false, nil
+}
+
+// campaignRewards prepares the rewards of both sides of a referral. The
+// rewards of a user who has not accepted the current terms stay pending
+// until they do.
+func (s *userService) campaignRewards(campaign *models.Campaign, referral *models.Referral, refereeAccepted bool) ([]*models.Reward, error) {
+	referrerAccepted, err := s.terms.HasAcceptedCurrent(referral.ReferredBy)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []*models.Reward{
+		{UserID: referral.ReferredBy, ReferralID: referral.ID, Kind: models.RewardKindReferrer, Amount: campaign.ReferrerReward},
+		{UserID: referral.ReferredID, ReferralID: referral.ID, Kind: models.RewardKindReferee, Amount: campaign.RefereeReward},
+	}
+	accepted := []bool{referrerAccepted, refereeAccepted}
+
+	var rewards []*models.Reward
+	for i, reward := range candidates {
+		if reward.Amount <= 0 {
+			continue
+		}
+
+		reward.CampaignID = referral.CampaignID
+		reward.Status = models.RewardStatusGranted
+		if !accepted[i] {
+			reward.Status = models.RewardStatusPending
+		}
+		rewards = append(rewards, reward)
+	}
+	return rewards, nil
+}
+
+// ApproveReferral qualifies a referral held for review, such as that of a
+// flagged signup, and grants the rewards of its campaign as if it had
+// qualified at signup.
+func (s *userService) ApproveReferral(referralID uint, meta models.RequestMeta) (*models.Referral, error) {
+	referral, err := s.referralRepo.GetByID(referralID)
+	if err != nil {
+		return nil, err
+	}
+	if referral.Status != models.ReferralStatusPending {
+		return nil, ErrReferralNotPending
+	}
+
+	var rewards []*models.Reward
+	if referral.CampaignID != nil {
+		campaign, err := s.campaignRepo.GetByID(*referral.CampaignID)
+		if err != nil {
+			return nil, err
+		}
+		refereeAccepted, err := s.terms.HasAcceptedCurrent(referral.ReferredID)
+		if err != nil {
+			return nil, err
+		}
+		if rewards, err = s.campaignRewards(campaign, referral, refereeAccepted); err != nil {
+			return nil, err
+		}
+	}
+
+	switch err := s.referralRepo.Qualify(referral, rewards); {
+	case errors.Is(err, repositories.ErrReferralNotPending):
+		return nil, ErrReferralNotPending
+	case err != nil:
+		return nil, err
+	}
+
+	s.auditService.Record(AuditEvent{
+		Meta:       meta,
+		Action:     models.AuditActionReferralApprove,
+		TargetType: models.AuditTargetReferral,
+		TargetID:   &referral.ID,
+		Before:     referralStatusAudit{Status: models.ReferralStatusPending},
+		After:      referralStatusAudit{Status: referral.Status, Rewards: len(rewards)},
+	})
+	return referral, nil
+}
+
+func (s *userService) GetReferrals(userID uint, opts ReferralListOptions) (*ReferralPage, error) {
+	filter, err := referralFilter(userID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.referralRepo.Count(filter)
 	if err != nil {
 		return nil, err
 	}
-	return referrals, nil
+
+	if opts.Cursor != "" {
+		createdAt, id, err := decodeReferralCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter.AfterCreatedAt = createdAt
+		filter.AfterID = id
+	}
+
+	// Fetch one extra row to find out whether another page follows.
+	filter.Limit = opts.Limit + 1
+	referrals, err := s.referralRepo.List(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ReferralPage{Total: total}
+	if len(referrals) > opts.Limit {
+		referrals = referrals[:opts.Limit]
+		last := referrals[len(referrals)-1]
+		page.NextCursor = encodeReferralCursor(last.CreatedAt, last.ID)
+	}
+
+	page.Referrals = make([]models.ReferralDetails, 0, len(referrals))
+	for _, referral := range referrals {
+		page.Referrals = append(page.Referrals, referralDetails(&referral, s.emailMasker))
+	}
+
+	return page, nil
+}
+
+func referralFilter(referrerID uint, opts ReferralListOptions) (repositories.ReferralFilter, error) {
+	filter := repositories.ReferralFilter{
+		ReferrerID: referrerID,
+		From:       opts.From,
+		To:         opts.To,
+		Status:     opts.Status,
+		Code:       opts.Code,
+		CampaignID: opts.CampaignID,
+	}
+
+	switch opts.Sort {
+	case "", ReferralSortCreatedAtDesc:
+	case ReferralSortCreatedAtAsc:
+		filter.Ascending = true
+	default:
+		return filter, ErrInvalidReferralSort
+	}
+
+	return filter, nil
+}
+
+func referralDetails(referral *models.Referral, emailMasker utils.EmailMasker) models.ReferralDetails {
+	details := models.ReferralDetails{
+		ID:           referral.ID,
+		ReferralCode: referral.ReferralCode,
+		Status:       referral.Status,
+		CreatedAt:    referral.CreatedAt,
+	}
+
+	// The referred user may have been deleted since, in which case only the
+	// referral itself is reported.
+	if referred := referral.Referred; referred != nil && referred.ID != 0 {
+		details.ReferredEmail = emailMasker.Mask(referred.Email)
+		details.ReferredSignedUpAt = referred.CreatedAt
+		details.ReferredVerified = referred.EmailVerifiedAt != nil
+	}
+
+	return details
 }
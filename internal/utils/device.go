@@ -0,0 +1,47 @@
+package utils
+
+import "strings"
+
+// DeviceName turns a user agent into a short description such as
+// "Firefox on Linux", good enough for users to recognise their devices.
+func DeviceName(userAgent string) string {
+	browser := firstMatch(userAgent, [][2]string{
+		// Order matters: Edge and Opera also claim to be Chrome, and Chrome
+		// claims to be Safari.
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	})
+	system := firstMatch(userAgent, [][2]string{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	})
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
+
+func firstMatch(userAgent string, patterns [][2]string) string {
+	for _, p := range patterns {
+		if strings.Contains(userAgent, p[0]) {
+			return p[1]
+		}
+	}
+	return ""
+}
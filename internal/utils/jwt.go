@@ -1,22 +1,31 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// TokenTTL is how long an access token, and the session it belongs to, lasts.
+const TokenTTL = 24 * time.Hour
+
 type JWTClaims struct {
-	UserID uint `json:"user_id"`
+	UserID    uint   `json:"user_id"`
+	Role      string `json:"role"`
+	SessionID uint   `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(userID uint, secret string) (string, error) {
+func GenerateJWT(userID uint, role string, sessionID uint, expiresAt time.Time, secret string) (string, error) {
 	claims := JWTClaims{
-		UserID: userID,
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "referral-system",
 		},
@@ -44,3 +53,51 @@ func ParseJWT(tokenStr string, secret string) (*JWTClaims, error) {
 
 	return nil, errors.New("invalid token")
 }
+
+// MagicLinkClaims identify a single-use login link. They are signed with a
+// key derived from the JWT secret, so a link can never pass as an access
+// token or the other way round.
+type MagicLinkClaims struct {
+	UserID uint `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+func GenerateMagicLinkJWT(userID uint, jti string, expiresAt time.Time, secret string) (string, error) {
+	claims := MagicLinkClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "referral-system",
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(magicLinkKey(secret))
+}
+
+func ParseMagicLinkJWT(tokenStr string, secret string) (*MagicLinkClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &MagicLinkClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return magicLinkKey(secret), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*MagicLinkClaims); ok && token.Valid && claims.ID != "" {
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid token")
+}
+
+func magicLinkKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("magic-link"))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,49 @@
+package utils
+
+import "strings"
+
+// EmailMasker hides most of an email address while keeping it recognisable to
+// the person who owns it, e.g. "jo***@example.com" or "jo***@e******.com".
+type EmailMasker struct {
+	VisibleChars int
+	MaskDomain   bool
+}
+
+func NewEmailMasker(visibleChars int, maskDomain bool) EmailMasker {
+	return EmailMasker{VisibleChars: visibleChars, MaskDomain: maskDomain}
+}
+
+func (m EmailMasker) Mask(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return strings.Repeat("*", len([]rune(email)))
+	}
+
+	return maskPart(email[:at], m.VisibleChars) + "***@" + m.maskDomain(email[at+1:])
+}
+
+func (m EmailMasker) maskDomain(domain string) string {
+	if !m.MaskDomain {
+		return domain
+	}
+
+	dot := strings.LastIndex(domain, ".")
+	if dot <= 0 {
+		return maskPart(domain, 1) + "***"
+	}
+
+	name := []rune(domain[:dot])
+	return string(name[:1]) + strings.Repeat("*", len(name)-1) + domain[dot:]
+}
+
+// maskPart keeps at most visible leading characters, always hiding at least one.
+func maskPart(part string, visible int) string {
+	runes := []rune(part)
+	if visible >= len(runes) {
+		visible = len(runes) - 1
+	}
+	if visible <= 0 {
+		return ""
+	}
+	return string(runes[:visible])
+}